@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxEmailLength       = 254
+)
+
+// bookingServiceURL — адрес booking-service для служебных запросов,
+// например http://booking-service:8082.
+var (
+	bookingServiceURL string
+	bookingClient     = &http.Client{Timeout: 10 * time.Second}
+)
+
+func loadAccountConfig() {
+	bookingServiceURL = strings.TrimRight(os.Getenv("BOOKING_SERVICE_URL"), "/")
+	if bookingServiceURL != "" && internalAPIKey == "" {
+		log.Fatalf("BOOKING_SERVICE_URL requires INTERNAL_API_KEY")
+	}
+}
+
+func userProfile(user *User) gin.H {
+	return gin.H{
+		"id":                 user.ID,
+		"username":           user.Username,
+		"role":               user.Role,
+		"display_name":       user.DisplayName,
+		"email":              user.Email,
+		"two_factor_enabled": user.TOTPEnabled,
+		"created_at":         user.CreatedAt,
+	}
+}
+
+// getMe — GET /me.
+func getMe(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"user": userProfile(currentUser(c))})
+}
+
+type profileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+}
+
+// validate нормализует поля на месте. Пустой email означает «удалить адрес».
+func (u *profileUpdate) validate() []fieldError {
+	var errs []fieldError
+	if u.DisplayName != nil {
+		name := strings.Join(strings.Fields(*u.DisplayName), " ")
+		u.DisplayName = &name
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			errs = append(errs, fieldError{"display_name", fmt.Sprintf("must not be longer than %d characters", maxDisplayNameLength)})
+		}
+	}
+	if u.Email != nil {
+		email := strings.TrimSpace(*u.Email)
+		u.Email = &email
+		if email != "" {
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email || len(email) > maxEmailLength {
+				errs = append(errs, fieldError{"email", "must be a valid email address"})
+			}
+		}
+	}
+	return errs
+}
+
+// updateMe — PATCH /me, меняет отображаемое имя и email.
+func updateMe(c *gin.Context) {
+	var update profileUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errs := update.validate(); len(errs) > 0 {
+		respondInvalidFields(c, "Invalid profile", errs)
+		return
+	}
+
+	user := currentUser(c)
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Email != nil {
+		user.Email = nil
+		if *update.Email != "" {
+			user.Email = update.Email
+		}
+	}
+	if err := db.UpdateProfile(user.ID, user.DisplayName, user.Email); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Update profile error: %v", err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": userProfile(user)})
+}
+
+type passwordChange struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password"`
+}
+
+// changePassword — PUT /me/password. Требует текущий пароль и завершает
+// все остальные сеансы: их refresh-токены отзываются, а уже выданные
+// access-токены доживают не дольше accessTokenTTL.
+func changePassword(c *gin.Context) {
+	var req passwordChange
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if errs := policy.validate(req.NewPassword, user.Username); len(errs) > 0 {
+		for i := range errs {
+			errs[i].Field = "new_password"
+		}
+		respondInvalidFields(c, "Invalid password", errs)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Hash password error: %v", err)
+		return
+	}
+	if err := db.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Update password error: %v", err)
+		return
+	}
+	if err := db.RevokeUserSessions(user.ID, c.GetString("sid"), time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Revoke sessions error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+type accountDeletion struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// oidcReauthWindow — насколько свежим должен быть вход через провайдера,
+// чтобы пользователь без пароля мог удалить учётную запись.
+const oidcReauthWindow = 10 * time.Minute
+
+// deleteMe — DELETE /me. Требует повторной проверки личности. Сначала
+// booking-service отменяет будущие бронирования пользователя, и только если
+// это удалось, удаляется сама учётная запись: иначе отменять было бы уже
+// некому.
+func deleteMe(c *gin.Context) {
+	var req accountDeletion
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// У пользователей провайдера пароля нет: их подтверждает код 2FA, а без
+	// неё — недавний вход через провайдера
+	user := currentUser(c)
+	switch {
+	case user.OIDCSubject == nil:
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect"})
+			return
+		}
+	case !user.TOTPEnabled:
+		if user.OIDCAuthAt == nil || now().Sub(*user.OIDCAuthAt) > oidcReauthWindow {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Sign in with your identity provider again to delete the account", "reauth_required": true})
+			return
+		}
+	}
+	if user.TOTPEnabled {
+		keys := codeAttemptKey(user)
+		if codeAttemptsBlocked(c, user, keys) {
+			return
+		}
+		ok, err := checkSecondFactor(user, req.Code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Check second factor error: %v", err)
+			return
+		}
+		if !ok {
+			recordLoginFailure(keys, user.Username, c.ClientIP(), failureInvalidCode)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid code"})
+			return
+		}
+	}
+
+	if err := cancelBookingsOf(user.ID); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not cancel bookings, please try again"})
+		log.Printf("Cancel bookings of user %d: %v", user.ID, err)
+		return
+	}
+	if err := db.DeleteUser(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Delete user error: %v", err)
+		return
+	}
+	if accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		if err := revokeAccessToken(accessToken); err != nil {
+			log.Printf("Revoke token error: %v", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// cancelBookingsOf просит booking-service отменить будущие бронирования.
+// Без BOOKING_SERVICE_URL шаг пропускается.
+func cancelBookingsOf(userID uint) error {
+	if bookingServiceURL == "" {
+		log.Printf("BOOKING_SERVICE_URL is not set, bookings of user %d are left as is", userID)
+		return nil
+	}
+	url := fmt.Sprintf("%s/internal/users/%d/cancel-bookings", bookingServiceURL, userID)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+internalAPIKey)
+	resp, err := bookingClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("booking-service returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/me", requireUser(), getMe)
+	r.PATCH("/me", requireUser(), updateMe)
+	r.PUT("/me/password", requireUser(), changePassword)
+	r.DELETE("/me", requireUser(), deleteMe)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correct-horse-42"), bcrypt.MinCost)
+	newDB := func(edit ...func(*User)) *MockDatabase {
+		user := &User{Username: "alice", Password: string(hashedPassword), Role: roleMember, DisplayName: "Alice"}
+		user.ID = 1
+		for _, f := range edit {
+			f(user)
+		}
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(user, nil)
+		return mockDB
+	}
+	send := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("get current user", func(t *testing.T) {
+		token := issueTestToken(t)
+		newDB()
+
+		resp := send(http.MethodGet, "/me", "", token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var body struct {
+			User map[string]interface{} `json:"user"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		assert.Equal(t, "alice", body.User["username"])
+		assert.Equal(t, "Alice", body.User["display_name"])
+		assert.Equal(t, false, body.User["two_factor_enabled"])
+		assert.NotContains(t, body.User, "password")
+	})
+
+	t.Run("update profile", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB()
+		mockDB.On("UpdateProfile", uint(1), "Alice Smith", mock.MatchedBy(func(email *string) bool {
+			return email != nil && *email == "alice@example.com"
+		})).Return(nil)
+
+		resp := send(http.MethodPatch, "/me", `{"display_name": "  Alice   Smith ", "email": " alice@example.com "}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"email":"alice@example.com"`)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("empty email clears it", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB()
+		mockDB.On("UpdateProfile", uint(1), "Alice", (*string)(nil)).Return(nil)
+
+		resp := send(http.MethodPatch, "/me", `{"email": ""}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("invalid email", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB()
+
+		resp := send(http.MethodPatch, "/me", `{"email": "Alice <alice@example.com>"}`, token)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "Invalid profile", "fields": [{"field": "email", "message": "must be a valid email address"}]}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "UpdateProfile", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB()
+		mockDB.On("UpdateProfile", mock.Anything, mock.Anything, mock.Anything).Return(gorm.ErrDuplicatedKey)
+
+		resp := send(http.MethodPatch, "/me", `{"email": "bob@example.com"}`, token)
+
+		assert.Equal(t, http.StatusConflict, resp.Code)
+	})
+
+	t.Run("change password revokes other sessions", func(t *testing.T) {
+		token := issueTestToken(t)
+		claims, _ := parseAccessToken(token)
+		mockDB := newDB()
+		mockDB.On("UpdatePassword", uint(1), mock.MatchedBy(func(hash string) bool {
+			return bcrypt.CompareHashAndPassword([]byte(hash), []byte("new-Password-7")) == nil
+		})).Return(nil)
+		mockDB.On("RevokeUserSessions", uint(1), claims["sid"], mock.Anything).Return(nil)
+
+		resp := send(http.MethodPut, "/me/password", `{"current_password": "correct-horse-42", "new_password": "new-Password-7"}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotEmpty(t, claims["sid"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("change password needs the current one", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB()
+
+		resp := send(http.MethodPut, "/me/password", `{"current_password": "wrong", "new_password": "new-Password-7"}`, token)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything)
+	})
+
+	t.Run("new password must follow the policy", func(t *testing.T) {
+		token := issueTestToken(t)
+		newDB()
+
+		resp := send(http.MethodPut, "/me/password", `{"current_password": "correct-horse-42", "new_password": "short"}`, token)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"field":"new_password"`)
+	})
+
+	t.Run("delete account cancels bookings first", func(t *testing.T) {
+		var calls []string
+		booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer booking.Close()
+		savedURL, savedKey := bookingServiceURL, internalAPIKey
+		defer func() { bookingServiceURL, internalAPIKey = savedURL, savedKey }()
+		bookingServiceURL, internalAPIKey = booking.URL, "key"
+
+		token := issueTestToken(t)
+		mockDB := newDB()
+		mockDB.On("DeleteUser", uint(1)).Return(nil)
+		mockDB.On("RevokeToken", mock.AnythingOfType("*main.RevokedToken")).Return(nil)
+
+		resp := send(http.MethodDelete, "/me", `{"password": "correct-horse-42"}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, []string{"POST /internal/users/1/cancel-bookings Bearer key"}, calls)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("account is kept if bookings cannot be cancelled", func(t *testing.T) {
+		booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer booking.Close()
+		savedURL, savedKey := bookingServiceURL, internalAPIKey
+		defer func() { bookingServiceURL, internalAPIKey = savedURL, savedKey }()
+		bookingServiceURL, internalAPIKey = booking.URL, "key"
+
+		token := issueTestToken(t)
+		mockDB := newDB()
+
+		resp := send(http.MethodDelete, "/me", `{"password": "correct-horse-42"}`, token)
+
+		assert.Equal(t, http.StatusBadGateway, resp.Code)
+		mockDB.AssertNotCalled(t, "DeleteUser", mock.Anything)
+	})
+
+	t.Run("delete account needs the password", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB()
+
+		resp := send(http.MethodDelete, "/me", `{"password": "wrong"}`, token)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "DeleteUser", mock.Anything)
+	})
+
+	oidcUser := func(authAt time.Time) func(*User) {
+		return func(u *User) {
+			subject, issuer := "idp-user-1", "https://idp.example"
+			u.Password = ""
+			u.OIDCSubject, u.OIDCIssuer = &subject, &issuer
+			u.OIDCAuthAt = &authAt
+		}
+	}
+
+	t.Run("SSO user deletes the account after a recent login", func(t *testing.T) {
+		booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer booking.Close()
+		savedURL, savedKey := bookingServiceURL, internalAPIKey
+		defer func() { bookingServiceURL, internalAPIKey = savedURL, savedKey }()
+		bookingServiceURL, internalAPIKey = booking.URL, "key"
+
+		token := issueTestToken(t)
+		mockDB := newDB(oidcUser(time.Now().Add(-time.Minute)))
+		mockDB.On("DeleteUser", uint(1)).Return(nil)
+		mockDB.On("RevokeToken", mock.AnythingOfType("*main.RevokedToken")).Return(nil)
+
+		resp := send(http.MethodDelete, "/me", `{}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("SSO user must sign in again after a while", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB(oidcUser(time.Now().Add(-time.Hour)))
+
+		resp := send(http.MethodDelete, "/me", `{"password": ""}`, token)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"reauth_required":true`)
+		mockDB.AssertNotCalled(t, "DeleteUser", mock.Anything)
+	})
+
+	t.Run("SSO user with 2FA confirms with a code", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := newDB(oidcUser(time.Now().Add(-time.Minute)), func(u *User) {
+			u.TOTPEnabled = true
+		})
+		mockDB.On("UseRecoveryCode", uint(1), mock.Anything, mock.Anything).Return(false, nil)
+		mockDB.On("CreateFailedLogin", mock.Anything).Return(nil)
+
+		resp := send(http.MethodDelete, "/me", `{"code": "aaaaa-bbbbb"}`, token)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.JSONEq(t, `{"error": "Invalid code"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "DeleteUser", mock.Anything)
+	})
+}
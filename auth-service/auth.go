@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// requireUser проверяет access-токен из заголовка Authorization, в том числе
+// по списку отзыва, и кладёт в контекст пользователя из базы.
+func requireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		claims, err := parseAccessToken(tokenStr)
+		userID, _ := claims["user_id"].(float64)
+		if err != nil || userID == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		if jti, _ := claims["jti"].(string); jti != "" {
+			revoked, err := db.IsTokenRevoked(jti)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				log.Printf("Check token revocation error: %v", err)
+				return
+			}
+			if revoked {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+				return
+			}
+		}
+
+		user, err := db.FindUserByID(uint(userID))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				log.Printf("Find user error: %v", err)
+			}
+			return
+		}
+		c.Set("user", user)
+		sid, _ := claims["sid"].(string)
+		c.Set("sid", sid)
+		c.Next()
+	}
+}
+
+// currentUser возвращает пользователя, положенного requireUser.
+func currentUser(c *gin.Context) *User {
+	return c.MustGet("user").(*User)
+}
+
+// requireInternalKey пропускает служебные запросы других сервисов с общим
+// секретом INTERNAL_API_KEY. Без секрета такие маршруты отключены.
+func requireInternalKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := "Bearer " + internalAPIKey
+		if internalAPIKey == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
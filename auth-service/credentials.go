@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bcrypt учитывает только первые 72 байта пароля, а более длинные
+// GenerateFromPassword отклоняет с ошибкой.
+const maxPasswordBytes = 72
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+)
+
+// passwordPolicy настраивается через PASSWORD_MIN_LENGTH, PASSWORD_MIN_CLASSES
+// и PASSWORD_BLOCKLIST_FILE. Классы символов: строчные и заглавные буквы,
+// цифры, прочие символы.
+type passwordPolicy struct {
+	MinLength  int
+	MinClasses int
+	blocklist  map[string]bool
+}
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+var policy = newPasswordPolicy()
+
+func newPasswordPolicy() *passwordPolicy {
+	p := &passwordPolicy{MinLength: 10, MinClasses: 2, blocklist: map[string]bool{}}
+	p.addBlocklist(strings.NewReader(commonPasswords))
+	return p
+}
+
+func (p *passwordPolicy) addBlocklist(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			p.blocklist[strings.ToLower(line)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+func loadPasswordPolicy() {
+	for env, value := range map[string]*int{
+		"PASSWORD_MIN_LENGTH":  &policy.MinLength,
+		"PASSWORD_MIN_CLASSES": &policy.MinClasses,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Fatalf("invalid %s: %q", env, v)
+			}
+			*value = n
+		}
+	}
+	if policy.MinClasses > 4 {
+		log.Fatalf("invalid PASSWORD_MIN_CLASSES: %d", policy.MinClasses)
+	}
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open PASSWORD_BLOCKLIST_FILE: %v", err)
+		}
+		defer f.Close()
+		if err := policy.addBlocklist(f); err != nil {
+			log.Fatalf("failed to read PASSWORD_BLOCKLIST_FILE: %v", err)
+		}
+	}
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func validateUsername(username string) []fieldError {
+	n := utf8.RuneCountInString(username)
+	switch {
+	case n == 0:
+		return []fieldError{{"username", "is required"}}
+	case n < minUsernameLength || n > maxUsernameLength:
+		return []fieldError{{"username", fmt.Sprintf("must be %d to %d characters long", minUsernameLength, maxUsernameLength)}}
+	case !usernamePattern.MatchString(username):
+		return []fieldError{{"username", "may contain only letters, digits, '.', '_' and '-', and must start with a letter or digit"}}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			n++
+		}
+	}
+	return n
+}
+
+// validate проверяет пароль и возвращает все нарушения сразу, чтобы
+// пользователь не исправлял их по одному.
+func (p *passwordPolicy) validate(password, username string) []fieldError {
+	if password == "" {
+		return []fieldError{{"password", "is required"}}
+	}
+
+	var errs []fieldError
+	if utf8.RuneCountInString(password) < p.MinLength {
+		errs = append(errs, fieldError{"password", fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+	if len(password) > maxPasswordBytes {
+		errs = append(errs, fieldError{"password", fmt.Sprintf("must not be longer than %d bytes", maxPasswordBytes)})
+	}
+	if characterClasses(password) < p.MinClasses {
+		errs = append(errs, fieldError{"password", fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses)})
+	}
+	lower := strings.ToLower(password)
+	if p.blocklist[lower] {
+		errs = append(errs, fieldError{"password", "is too common"})
+	}
+	if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		errs = append(errs, fieldError{"password", "must not contain the username"})
+	}
+	return errs
+}
+
+func respondInvalidFields(c *gin.Context, message string, errs []fieldError) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "fields": errs})
+}
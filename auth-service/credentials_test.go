@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUsername(t *testing.T) {
+	for _, name := range []string{"bob", "alice.smith", "user_01", "a-b-c"} {
+		assert.Empty(t, validateUsername(name), name)
+	}
+	for _, name := range []string{"", "ab", strings.Repeat("a", 33), "-bob", "bob smith", "bob@example", "боб"} {
+		assert.NotEmpty(t, validateUsername(name), name)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	p := newPasswordPolicy()
+
+	tests := []struct {
+		password string
+		username string
+		want     []string
+	}{
+		{"correct-horse-42", "bob", nil},
+		{"", "bob", []string{"is required"}},
+		{"short1", "bob", []string{"must be at least 10 characters long"}},
+		{"onlylowercaseletters", "bob", []string{"must contain at least 2 of: lowercase letters, uppercase letters, digits, symbols"}},
+		{"Password123", "bob", []string{"is too common"}},
+		{"QWERTYUIOP123", "bob", []string{"is too common"}},
+		{"bob-is-great-1", "Bob", []string{"must not contain the username"}},
+		{strings.Repeat("é", 40), "bob", []string{
+			"must not be longer than 72 bytes",
+			"must contain at least 2 of: lowercase letters, uppercase letters, digits, symbols",
+		}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, e := range p.validate(tt.password, tt.username) {
+			assert.Equal(t, "password", e.Field)
+			got = append(got, e.Message)
+		}
+		assert.Equal(t, tt.want, got, tt.password)
+	}
+
+	t.Run("configurable", func(t *testing.T) {
+		p := newPasswordPolicy()
+		p.MinLength = 4
+		p.MinClasses = 4
+		assert.NotEmpty(t, p.validate("abcD1", "bob"))
+		assert.Empty(t, p.validate("abD1!", "bob"))
+
+		assert.NoError(t, p.addBlocklist(strings.NewReader("# comment\nabD1!\n")))
+		assert.Equal(t, []fieldError{{"password", "is too common"}}, p.validate("ABd1!", "bob"))
+	})
+}
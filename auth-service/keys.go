@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// keyring — RSA-ключи подписи JWT, различаемые по заголовку "kid".
+// Токены подписываются ключом signingKID, а открытые части всех ключей
+// публикуются в JWKS, чтобы после ротации старые токены ещё проверялись.
+type keyring struct {
+	keys       map[string]*rsa.PrivateKey
+	order      []string
+	signingKID string
+}
+
+var jwtKeys *keyring
+
+func newKeyring() *keyring {
+	return &keyring{keys: map[string]*rsa.PrivateKey{}}
+}
+
+func (kr *keyring) add(kid string, key *rsa.PrivateKey) error {
+	if _, dup := kr.keys[kid]; dup {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+	kr.keys[kid] = key
+	kr.order = append(kr.order, kid)
+	if kr.signingKID == "" {
+		kr.signingKID = kid
+	}
+	return nil
+}
+
+// parseKeyring разбирает строку вида "2024-01:/keys/old.pem,2024-06:/keys/new.pem".
+// Если signingKID пуст, подписываем первым ключом из списка.
+func parseKeyring(spec, signingKID string) (*keyring, error) {
+	kr := newKeyring()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("malformed key entry %q", entry)
+		}
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read key %q: %w", kid, err)
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parse key %q: %w", kid, err)
+		}
+		if err := kr.add(kid, key); err != nil {
+			return nil, err
+		}
+	}
+	if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("no signing keys configured")
+	}
+	if signingKID != "" {
+		if _, ok := kr.keys[signingKID]; !ok {
+			return nil, fmt.Errorf("signing key %q is not in JWT_PRIVATE_KEYS", signingKID)
+		}
+		kr.signingKID = signingKID
+	}
+	return kr, nil
+}
+
+// loadKeyring читает ключи из JWT_PRIVATE_KEYS и JWT_SIGNING_KID. Без
+// настроенных ключей генерирует временный: это удобно для разработки, но
+// после перезапуска все выданные токены станут недействительны.
+func loadKeyring() (*keyring, error) {
+	spec := os.Getenv("JWT_PRIVATE_KEYS")
+	if spec != "" {
+		return parseKeyring(spec, os.Getenv("JWT_SIGNING_KID"))
+	}
+
+	log.Printf("JWT_PRIVATE_KEYS is not set, generating an ephemeral signing key")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kr := newKeyring()
+	kr.add(fmt.Sprintf("ephemeral-%d", time.Now().Unix()), key)
+	return kr, nil
+}
+
+func (kr *keyring) signingKey() (string, *rsa.PrivateKey) {
+	return kr.signingKID, kr.keys[kr.signingKID]
+}
+
+// sign подписывает claims текущим ключом и проставляет его "kid".
+func (kr *keyring) sign(claims jwt.Claims) (string, error) {
+	kid, key := kr.signingKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// jwk — открытый RSA-ключ в формате RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func (kr *keyring) jwks() []jwk {
+	keys := make([]jwk, 0, len(kr.order))
+	for _, kid := range kr.order {
+		pub := kr.keys[kid].PublicKey
+		keys = append(keys, jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	return keys
+}
+
+func getJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": jwtKeys.jwks()})
+}
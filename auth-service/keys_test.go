@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeKey(t *testing.T, dir, name string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600))
+	return key
+}
+
+func TestParseKeyring(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old.pem")
+	newKey := writeKey(t, dir, "new.pem")
+	spec := "old:" + filepath.Join(dir, "old.pem") + ",new:" + filepath.Join(dir, "new.pem")
+
+	kr, err := parseKeyring(spec, "new")
+	require.NoError(t, err)
+	kid, key := kr.signingKey()
+	assert.Equal(t, "new", kid)
+	assert.Equal(t, newKey.N, key.N)
+
+	kr, err = parseKeyring(spec, "")
+	require.NoError(t, err)
+	kid, _ = kr.signingKey()
+	assert.Equal(t, "old", kid)
+
+	_, err = parseKeyring(spec, "missing")
+	assert.Error(t, err)
+	_, err = parseKeyring("", "")
+	assert.Error(t, err)
+	_, err = parseKeyring("x:"+filepath.Join(dir, "absent.pem"), "")
+	assert.Error(t, err)
+}
+
+// jwksKey восстанавливает открытый ключ из ответа /.well-known/jwks.json.
+func jwksKey(t *testing.T, body []byte, kid string) *rsa.PublicKey {
+	var response struct {
+		Keys []jwk `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(body, &response))
+	for _, k := range response.Keys {
+		if k.Kid == kid {
+			assert.Equal(t, "RS256", k.Alg)
+			n, _ := base64.RawURLEncoding.DecodeString(k.N)
+			e, _ := base64.RawURLEncoding.DecodeString(k.E)
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		}
+	}
+	t.Fatalf("key %q not published", kid)
+	return nil
+}
+
+func TestLoginTokenVerifiesAgainstJWKS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	saved := jwtKeys
+	defer func() { jwtKeys = saved }()
+
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwtKeys = newKeyring()
+	jwtKeys.add("old", oldKey)
+	jwtKeys.add("new", newKey)
+	jwtKeys.signingKID = "new"
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	user := &User{Username: "testuser", Password: string(hashedPassword)}
+	user.ID = 1
+	mockDB := new(MockDatabase)
+	db = mockDB
+	mockDB.On("FindUserByUsername", "testuser").Return(user, nil)
+	mockDB.On("CreateRefreshToken", mock.AnythingOfType("*main.RefreshToken")).Return(nil)
+
+	r := gin.Default()
+	r.POST("/login", login)
+	r.GET("/.well-known/jwks.json", getJWKS)
+
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"username": "testuser", "password": "password"}`))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var loginResponse map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &loginResponse)
+
+	req, _ = http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	jwksResp := httptest.NewRecorder()
+	r.ServeHTTP(jwksResp, req)
+	require.Equal(t, http.StatusOK, jwksResp.Code)
+
+	// Опубликованы оба ключа, токен подписан новым
+	jwksKey(t, jwksResp.Body.Bytes(), "old")
+	token, err := jwt.Parse(loginResponse["token"].(string), func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, "RS256", token.Header["alg"])
+		return jwksKey(t, jwksResp.Body.Bytes(), token.Header["kid"].(string)), nil
+	})
+	require.NoError(t, err)
+	assert.True(t, token.Valid)
+	assert.Equal(t, "new", token.Header["kid"])
+}
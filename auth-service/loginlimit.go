@@ -0,0 +1,253 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var now = time.Now
+
+// loginLimit задаёт, как наказываются неудачные входы по одному ключу
+// (имени пользователя или IP). Первые FreeAttempts неудач проходят без
+// задержки, дальше каждая удваивает паузу до MaxDelay, а после LockoutAfter
+// ключ блокируется на LockoutDuration. Счётчик обнуляется, если неудач не
+// было дольше Window.
+type loginLimit struct {
+	FreeAttempts    int
+	LockoutAfter    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// С одного IP могут входить многие пользователи (NAT, офис), поэтому
+// порог для IP заметно выше.
+var (
+	usernameLimit = loginLimit{3, 10, time.Second, 5 * time.Minute, 15 * time.Minute, time.Hour}
+	ipLimit       = loginLimit{20, 100, time.Second, 5 * time.Minute, 15 * time.Minute, time.Hour}
+)
+
+// blockedUntil возвращает момент, до которого ключ блокируется после
+// failures неудач подряд.
+func (l loginLimit) blockedUntil(failures int, at time.Time) time.Time {
+	switch {
+	case failures >= l.LockoutAfter:
+		return at.Add(l.LockoutDuration)
+	case failures <= l.FreeAttempts:
+		return time.Time{}
+	}
+	shift := failures - l.FreeAttempts - 1
+	delay := time.Duration(float64(l.BaseDelay) * math.Pow(2, float64(shift)))
+	if delay <= 0 || delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+	return at.Add(delay)
+}
+
+func loadLoginLimits() {
+	for env, value := range map[string]*int{
+		"LOGIN_LOCKOUT_THRESHOLD":    &usernameLimit.LockoutAfter,
+		"LOGIN_IP_LOCKOUT_THRESHOLD": &ipLimit.LockoutAfter,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("invalid %s: %q", env, v)
+			}
+			*value = n
+		}
+	}
+	if v := os.Getenv("LOGIN_LOCKOUT_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid LOGIN_LOCKOUT_DURATION: %q", v)
+		}
+		usernameLimit.LockoutDuration = d
+		ipLimit.LockoutDuration = d
+	}
+}
+
+// loginAttempt — состояние счётчика неудач одного ключа.
+type loginAttempt struct {
+	Failures     int
+	BlockedUntil time.Time
+}
+
+// AttemptStore хранит счётчики неудачных входов. Хранилище общее для всех
+// реплик auth-service, иначе перебор можно было бы распределить между ними.
+type AttemptStore interface {
+	Get(key string) (loginAttempt, error)
+	// Fail атомарно учитывает неудачу. Если предыдущая была раньше
+	// windowStart, счётчик начинается заново.
+	Fail(key string, at, windowStart time.Time, limit loginLimit) (loginAttempt, error)
+	Reset(key string) error
+}
+
+var attempts AttemptStore
+
+// LoginAttempt — строка счётчика в Postgres.
+type LoginAttempt struct {
+	Subject      string `gorm:"primaryKey"`
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+type gormAttemptStore struct {
+	Conn *gorm.DB
+}
+
+func (s *gormAttemptStore) Get(key string) (loginAttempt, error) {
+	var row LoginAttempt
+	err := s.Conn.Where("subject = ?", key).Limit(1).Find(&row).Error
+	return loginAttempt{Failures: row.Failures, BlockedUntil: row.BlockedUntil}, err
+}
+
+// Fail увеличивает счётчик одним upsert'ом, поэтому параллельные неудачи
+// на разных репликах не теряются. Блокировка только продлевается.
+func (s *gormAttemptStore) Fail(key string, at, windowStart time.Time, limit loginLimit) (loginAttempt, error) {
+	var row LoginAttempt
+	err := s.Conn.Transaction(func(tx *gorm.DB) error {
+		err := tx.Raw(`
+INSERT INTO login_attempts (subject, failures, last_failure, blocked_until) VALUES (?, 1, ?, ?)
+ON CONFLICT (subject) DO UPDATE SET
+	failures = CASE WHEN login_attempts.last_failure < ? THEN 1 ELSE login_attempts.failures + 1 END,
+	last_failure = EXCLUDED.last_failure
+RETURNING subject, failures, last_failure, blocked_until`, key, at, time.Time{}, windowStart).Scan(&row).Error
+		if err != nil {
+			return err
+		}
+		until := limit.blockedUntil(row.Failures, at)
+		if until.After(row.BlockedUntil) {
+			row.BlockedUntil = until
+			return tx.Exec("UPDATE login_attempts SET blocked_until = GREATEST(blocked_until, ?) WHERE subject = ?", until, key).Error
+		}
+		return nil
+	})
+	return loginAttempt{Failures: row.Failures, BlockedUntil: row.BlockedUntil}, err
+}
+
+func (s *gormAttemptStore) Reset(key string) error {
+	return s.Conn.Where("subject = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+// memoryAttemptStore хранит счётчики в памяти процесса. Подходит для тестов
+// и для единственной реплики.
+type memoryAttemptStore struct {
+	mu   sync.Mutex
+	rows map[string]*LoginAttempt
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{rows: map[string]*LoginAttempt{}}
+}
+
+func (s *memoryAttemptStore) Get(key string) (loginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.rows[key]; ok {
+		return loginAttempt{Failures: row.Failures, BlockedUntil: row.BlockedUntil}, nil
+	}
+	return loginAttempt{}, nil
+}
+
+func (s *memoryAttemptStore) Fail(key string, at, windowStart time.Time, limit loginLimit) (loginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.rows[key]
+	if !ok {
+		row = &LoginAttempt{Subject: key}
+		s.rows[key] = row
+	}
+	if row.LastFailure.Before(windowStart) {
+		row.Failures = 0
+	}
+	row.Failures++
+	row.LastFailure = at
+	if until := limit.blockedUntil(row.Failures, at); until.After(row.BlockedUntil) {
+		row.BlockedUntil = until
+	}
+	return loginAttempt{Failures: row.Failures, BlockedUntil: row.BlockedUntil}, nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rows, key)
+	return nil
+}
+
+// FailedLogin — журнал неудачных входов для разбора инцидентов.
+type FailedLogin struct {
+	gorm.Model
+	Username string `gorm:"index"`
+	IP       string `gorm:"index"`
+	Reason   string
+}
+
+const (
+	failureInvalidCredentials = "invalid_credentials"
+	failureInvalidCode        = "invalid_code"
+	failureLocked             = "locked"
+)
+
+type loginKey struct {
+	key   string
+	limit loginLimit
+}
+
+func loginKeys(username, ip string) []loginKey {
+	return []loginKey{
+		{"user:" + strings.ToLower(username), usernameLimit},
+		{"ip:" + ip, ipLimit},
+	}
+}
+
+// loginBlockedUntil возвращает самую позднюю блокировку среди ключей.
+func loginBlockedUntil(keys []loginKey) (time.Time, error) {
+	var until time.Time
+	for _, k := range keys {
+		state, err := attempts.Get(k.key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if state.BlockedUntil.After(until) {
+			until = state.BlockedUntil
+		}
+	}
+	return until, nil
+}
+
+// recordLoginFailure учитывает неудачу в счётчиках и пишет её в журнал.
+// Отказ из-за уже действующей блокировки в счётчиках не учитывается, иначе
+// она продлевалась бы сама собой.
+func recordLoginFailure(keys []loginKey, username, ip, reason string) {
+	if reason != failureLocked {
+		at := now()
+		for _, k := range keys {
+			if _, err := attempts.Fail(k.key, at, at.Add(-k.limit.Window), k.limit); err != nil {
+				log.Printf("Record login failure error: %v", err)
+			}
+		}
+	}
+	log.Printf("Failed login for %q from %s: %s", username, ip, reason)
+	if err := db.CreateFailedLogin(&FailedLogin{Username: username, IP: ip, Reason: reason}); err != nil {
+		log.Printf("Audit failed login error: %v", err)
+	}
+}
+
+func tooManyAttempts(c *gin.Context, until time.Time) {
+	seconds := int(math.Ceil(until.Sub(now()).Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts"})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestLoginLimitBlockedUntil(t *testing.T) {
+	l := loginLimit{FreeAttempts: 3, LockoutAfter: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second, LockoutDuration: time.Hour}
+	at := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for failures, want := range map[int]time.Duration{
+		1:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		6:  4 * time.Second,
+		7:  5 * time.Second,
+		9:  5 * time.Second,
+		10: time.Hour,
+		50: time.Hour,
+	} {
+		until := l.blockedUntil(failures, at)
+		if want == 0 {
+			assert.True(t, until.IsZero(), "failures=%d", failures)
+		} else {
+			assert.Equal(t, at.Add(want), until, "failures=%d", failures)
+		}
+	}
+}
+
+func TestMemoryAttemptStore(t *testing.T) {
+	s := newMemoryAttemptStore()
+	l := loginLimit{FreeAttempts: 1, LockoutAfter: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	at := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.Fail("k", at, at.Add(-l.Window), l)
+	state, _ := s.Fail("k", at, at.Add(-l.Window), l)
+	assert.Equal(t, 2, state.Failures)
+	assert.Equal(t, at.Add(time.Minute), state.BlockedUntil)
+
+	// После паузы дольше окна счётчик начинается заново, блокировка не сокращается
+	later := at.Add(2 * time.Hour)
+	state, _ = s.Fail("k", later, later.Add(-l.Window), l)
+	assert.Equal(t, 1, state.Failures)
+	assert.Equal(t, at.Add(time.Minute), state.BlockedUntil)
+
+	s.Reset("k")
+	state, _ = s.Get("k")
+	assert.Equal(t, loginAttempt{}, state)
+}
+
+func TestLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	savedNow, savedUser, savedIP, savedAttempts := now, usernameLimit, ipLimit, attempts
+	defer func() { now, usernameLimit, ipLimit, attempts = savedNow, savedUser, savedIP, savedAttempts }()
+
+	clock := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	usernameLimit = loginLimit{FreeAttempts: 2, LockoutAfter: 4, BaseDelay: 10 * time.Second, MaxDelay: time.Minute, LockoutDuration: 15 * time.Minute, Window: time.Hour}
+	ipLimit = loginLimit{FreeAttempts: 5, LockoutAfter: 6, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutDuration: time.Hour, Window: time.Hour}
+
+	r := gin.Default()
+	r.POST("/login", login)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correct-horse-42"), bcrypt.MinCost)
+	newDB := func() *MockDatabase {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		for _, name := range []string{"alice", "bob"} {
+			user := &User{Username: name, Password: string(hashedPassword), Role: roleMember}
+			user.ID = 1
+			mockDB.On("FindUserByUsername", name).Return(user, nil)
+		}
+		mockDB.On("FindUserByUsername", "carol").Return((*User)(nil), gorm.ErrRecordNotFound)
+		mockDB.On("CreateRefreshToken", mock.Anything).Return(nil)
+		mockDB.On("CreateFailedLogin", mock.Anything).Return(nil)
+		return mockDB
+	}
+	loginAs := func(username, password, ip string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/login",
+			bytes.NewBufferString(`{"username": "`+username+`", "password": "`+password+`"}`))
+		req.RemoteAddr = ip + ":1234"
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("backoff, lockout and reset", func(t *testing.T) {
+		attempts = newMemoryAttemptStore()
+		mockDB := newDB()
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusUnauthorized, loginAs("alice", "wrong", "10.0.0.1").Code)
+		}
+		resp := loginAs("alice", "correct-horse-42", "10.0.0.1")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.Equal(t, "10", resp.Header().Get("Retry-After"))
+		assert.JSONEq(t, `{"error": "Too many login attempts"}`, resp.Body.String())
+
+		clock = clock.Add(10 * time.Second)
+		assert.Equal(t, http.StatusUnauthorized, loginAs("alice", "wrong", "10.0.0.1").Code)
+		resp = loginAs("alice", "wrong", "10.0.0.1")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.Equal(t, "900", resp.Header().Get("Retry-After"))
+
+		clock = clock.Add(15 * time.Minute)
+		assert.Equal(t, http.StatusOK, loginAs("alice", "correct-horse-42", "10.0.0.1").Code)
+		state, _ := attempts.Get("user:alice")
+		assert.Equal(t, loginAttempt{}, state)
+
+		mockDB.AssertCalled(t, "CreateFailedLogin", mock.MatchedBy(func(entry *FailedLogin) bool {
+			return entry.Username == "alice" && entry.IP == "10.0.0.1" && entry.Reason == failureLocked
+		}))
+	})
+
+	t.Run("per-IP limit spans usernames", func(t *testing.T) {
+		attempts = newMemoryAttemptStore()
+		newDB()
+
+		for i := 0; i < 6; i++ {
+			username := []string{"alice", "bob", "carol"}[i%3]
+			assert.Equal(t, http.StatusUnauthorized, loginAs(username, "wrong", "10.0.0.2").Code)
+		}
+		resp := loginAs("bob", "correct-horse-42", "10.0.0.2")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.Equal(t, "3600", resp.Header().Get("Retry-After"))
+
+		// Тот же пользователь с другого адреса не заблокирован
+		assert.Equal(t, http.StatusOK, loginAs("bob", "correct-horse-42", "10.0.0.3").Code)
+	})
+}
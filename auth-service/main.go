@@ -4,14 +4,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var db Database
@@ -20,16 +21,60 @@ var db Database
 type Database interface {
 	CreateUser(user *User) error
 	FindUserByUsername(username string) (*User, error)
+	FindUserByID(id uint) (*User, error)
+	SetUserRole(id uint, role string) error
+	GrantFirstAdmin(id uint) (bool, error)
+	CreateRefreshToken(token *RefreshToken) error
+	FindRefreshToken(hash string) (*RefreshToken, error)
+	MarkRefreshTokenUsed(id uint, at time.Time) (bool, error)
+	RevokeRefreshFamily(familyID string, at time.Time) error
+	RevokeToken(token *RevokedToken) error
+	ListRevokedTokens(since, now time.Time) ([]RevokedToken, error)
+	CreateFailedLogin(entry *FailedLogin) error
+	IsTokenRevoked(jti string) (bool, error)
+	SetTOTPSecret(userID uint, secret string) error
+	EnableTOTP(userID uint, step int64, recoveryHashes []string) error
+	DisableTOTP(userID uint) error
+	MarkTOTPStepUsed(userID uint, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID uint, hashes []string) error
+	UseRecoveryCode(userID uint, hash string, at time.Time) (bool, error)
+	CreateLoginChallenge(challenge *LoginChallenge) error
+	FindLoginChallenge(hash string) (*LoginChallenge, error)
+	IncrementChallengeAttempts(id uint) error
+	DeleteLoginChallenge(id uint) (bool, error)
+	UpdateProfile(userID uint, displayName string, email *string) error
+	UpdatePassword(userID uint, hash string) error
+	RevokeUserSessions(userID uint, exceptFamilyID string, at time.Time) error
+	DeleteUser(userID uint) error
+	CreatePasswordReset(reset *PasswordReset, notBefore time.Time) (bool, error)
+	FindPasswordReset(hash string) (*PasswordReset, error)
+	MarkPasswordResetUsed(id uint, at time.Time) (bool, error)
+	FindUserByOIDCSubject(issuer, subject string) (*User, error)
+	SetOIDCAuthTime(userID uint, at time.Time) error
+	CreateOIDCLogin(login *OIDCLogin) error
+	TakeOIDCLogin(stateHash string) (*OIDCLogin, error)
+	CreateServiceAccount(account *ServiceAccount) error
+	FindServiceAccount(id uint) (*ServiceAccount, error)
+	ListServiceAccounts() ([]ServiceAccount, error)
+	DeleteServiceAccount(id uint) error
+	CreateAPIKey(key *APIKey) error
+	ListAPIKeys(accountID uint) ([]APIKey, error)
+	FindAPIKeyByPrefix(prefix string) (*APIKey, error)
+	RevokeAPIKey(accountID, keyID uint, at time.Time) (bool, error)
+	TouchAPIKey(id uint, at, notAfter time.Time) error
 }
 
 func initDB() {
 	dsn := os.Getenv("DATABASE_URL")
-	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError превращает нарушение уникальности в gorm.ErrDuplicatedKey
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("failed to connect to the database: %v", err)
 	}
-	database.AutoMigrate(&User{})
+	database.AutoMigrate(&User{}, &RefreshToken{}, &RevokedToken{}, &LoginAttempt{}, &FailedLogin{}, &RecoveryCode{}, &LoginChallenge{}, &PasswordReset{}, &OIDCLogin{},
+		&ServiceAccount{}, &APIKey{})
 	db = &GormDatabase{Conn: database}
+	attempts = &gormAttemptStore{Conn: database}
 }
 
 type GormDatabase struct {
@@ -49,10 +94,345 @@ func (g *GormDatabase) FindUserByUsername(username string) (*User, error) {
 	return &user, nil
 }
 
+func (g *GormDatabase) FindUserByID(id uint) (*User, error) {
+	var user User
+	if err := g.Conn.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (g *GormDatabase) SetUserRole(id uint, role string) error {
+	result := g.Conn.Model(&User{}).Where("id = ?", id).Update("role", role)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// GrantFirstAdmin назначает пользователя администратором, только если
+// администраторов ещё нет. Проверка и назначение — один запрос, поэтому
+// одновременно стартующие реплики не назначат двоих.
+func (g *GormDatabase) GrantFirstAdmin(id uint) (bool, error) {
+	result := g.Conn.Model(&User{}).
+		Where("id = ? AND NOT EXISTS (SELECT 1 FROM users WHERE role = ? AND deleted_at IS NULL)", id, roleAdmin).
+		Update("role", roleAdmin)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) CreateRefreshToken(token *RefreshToken) error {
+	return g.Conn.Create(token).Error
+}
+
+func (g *GormDatabase) FindRefreshToken(hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := g.Conn.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed возвращает false, если токен уже был использован.
+func (g *GormDatabase) MarkRefreshTokenUsed(id uint, at time.Time) (bool, error) {
+	result := g.Conn.Model(&RefreshToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) RevokeRefreshFamily(familyID string, at time.Time) error {
+	return g.Conn.Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", at).Error
+}
+
+// RevokeToken не считает ошибкой повторный отзыв того же jti.
+func (g *GormDatabase) RevokeToken(token *RevokedToken) error {
+	return g.Conn.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "jti"}}, DoNothing: true}).Create(token).Error
+}
+
+func (g *GormDatabase) ListRevokedTokens(since, now time.Time) ([]RevokedToken, error) {
+	var tokens []RevokedToken
+	err := g.Conn.Where("created_at > ? AND expires_at > ?", since, now).Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
+
+func (g *GormDatabase) CreateFailedLogin(entry *FailedLogin) error {
+	return g.Conn.Create(entry).Error
+}
+
+func (g *GormDatabase) IsTokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := g.Conn.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// SetTOTPSecret сохраняет секрет, ещё не подтверждённый кодом.
+func (g *GormDatabase) SetTOTPSecret(userID uint, secret string) error {
+	return g.Conn.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": false, "totp_last_step": 0}).Error
+}
+
+func (g *GormDatabase) EnableTOTP(userID uint, step int64, recoveryHashes []string) error {
+	return g.Conn.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, recoveryHashes)
+	})
+}
+
+func (g *GormDatabase) DisableTOTP(userID uint) error {
+	return g.Conn.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, nil)
+	})
+}
+
+// MarkTOTPStepUsed возвращает false, если этот или более поздний шаг уже
+// был использован: так один код не пройдёт дважды даже на разных репликах.
+func (g *GormDatabase) MarkTOTPStepUsed(userID uint, step int64) (bool, error) {
+	result := g.Conn.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	return g.Conn.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, hashes []string) error {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	codes := make([]RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+	return tx.Create(&codes).Error
+}
+
+func (g *GormDatabase) UseRecoveryCode(userID uint, hash string, at time.Time) (bool, error) {
+	result := g.Conn.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) CreateLoginChallenge(challenge *LoginChallenge) error {
+	return g.Conn.Create(challenge).Error
+}
+
+func (g *GormDatabase) FindLoginChallenge(hash string) (*LoginChallenge, error) {
+	var challenge LoginChallenge
+	if err := g.Conn.Where("token_hash = ?", hash).First(&challenge).Error; err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (g *GormDatabase) IncrementChallengeAttempts(id uint) error {
+	return g.Conn.Model(&LoginChallenge{}).Where("id = ?", id).Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+func (g *GormDatabase) DeleteLoginChallenge(id uint) (bool, error) {
+	result := g.Conn.Unscoped().Delete(&LoginChallenge{}, id)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) UpdateProfile(userID uint, displayName string, email *string) error {
+	return g.Conn.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"display_name": displayName, "email": email}).Error
+}
+
+func (g *GormDatabase) UpdatePassword(userID uint, hash string) error {
+	return g.Conn.Model(&User{}).Where("id = ?", userID).Update("password", hash).Error
+}
+
+// RevokeUserSessions отзывает все семейства refresh-токенов пользователя,
+// кроме exceptFamilyID.
+func (g *GormDatabase) RevokeUserSessions(userID uint, exceptFamilyID string, at time.Time) error {
+	return g.Conn.Model(&RefreshToken{}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, exceptFamilyID).
+		Update("revoked_at", at).Error
+}
+
+// DeleteUser удаляет пользователя вместе с токенами и кодами без
+// возможности восстановления; журнал неудачных входов остаётся.
+func (g *GormDatabase) DeleteUser(userID uint) error {
+	return g.Conn.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&RefreshToken{}, &RecoveryCode{}, &LoginChallenge{}, &PasswordReset{}} {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&User{}, userID).Error
+	})
+}
+
+// CreatePasswordReset заменяет прежние токены сброса пользователя новым.
+// Если после notBefore уже выпускался токен, ничего не делает и возвращает
+// false. Строка пользователя блокируется, чтобы параллельные запросы не
+// обошли эту проверку.
+func (g *GormDatabase) CreatePasswordReset(reset *PasswordReset, notBefore time.Time) (bool, error) {
+	created := false
+	err := g.Conn.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, reset.UserID).Error; err != nil {
+			return err
+		}
+		var recent int64
+		if err := tx.Model(&PasswordReset{}).
+			Where("user_id = ? AND created_at > ?", reset.UserID, notBefore).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			return nil
+		}
+		if err := tx.Unscoped().Where("user_id = ?", reset.UserID).Delete(&PasswordReset{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(reset).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+func (g *GormDatabase) FindPasswordReset(hash string) (*PasswordReset, error) {
+	var reset PasswordReset
+	if err := g.Conn.Where("token_hash = ?", hash).First(&reset).Error; err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// MarkPasswordResetUsed гасит токен, только если он ещё не использован.
+func (g *GormDatabase) MarkPasswordResetUsed(id uint, at time.Time) (bool, error) {
+	result := g.Conn.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) FindUserByOIDCSubject(issuer, subject string) (*User, error) {
+	var user User
+	if err := g.Conn.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (g *GormDatabase) SetOIDCAuthTime(userID uint, at time.Time) error {
+	return g.Conn.Model(&User{}).Where("id = ?", userID).Update("oidc_auth_at", at).Error
+}
+
+func (g *GormDatabase) CreateOIDCLogin(login *OIDCLogin) error {
+	return g.Conn.Create(login).Error
+}
+
+// TakeOIDCLogin удаляет запись и возвращает её; из двух запросов с одним
+// state запись получит только один.
+func (g *GormDatabase) TakeOIDCLogin(stateHash string) (*OIDCLogin, error) {
+	var login OIDCLogin
+	result := g.Conn.Unscoped().Clauses(clause.Returning{}).Where("state_hash = ?", stateHash).Delete(&login)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &login, nil
+}
+
+func (g *GormDatabase) CreateServiceAccount(account *ServiceAccount) error {
+	return g.Conn.Create(account).Error
+}
+
+func (g *GormDatabase) FindServiceAccount(id uint) (*ServiceAccount, error) {
+	var account ServiceAccount
+	if err := g.Conn.First(&account, id).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (g *GormDatabase) ListServiceAccounts() ([]ServiceAccount, error) {
+	var accounts []ServiceAccount
+	err := g.Conn.Order("name").Find(&accounts).Error
+	return accounts, err
+}
+
+func (g *GormDatabase) DeleteServiceAccount(id uint) error {
+	return g.Conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("service_account_id = ?", id).Delete(&APIKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&ServiceAccount{}, id).Error
+	})
+}
+
+func (g *GormDatabase) CreateAPIKey(key *APIKey) error {
+	return g.Conn.Create(key).Error
+}
+
+func (g *GormDatabase) ListAPIKeys(accountID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := g.Conn.Where("service_account_id = ?", accountID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (g *GormDatabase) FindAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	var key APIKey
+	if err := g.Conn.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (g *GormDatabase) RevokeAPIKey(accountID, keyID uint, at time.Time) (bool, error) {
+	result := g.Conn.Model(&APIKey{}).
+		Where("id = ? AND service_account_id = ? AND revoked_at IS NULL", keyID, accountID).
+		Update("revoked_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+// TouchAPIKey записывает время использования ключа, если прошлое записанное
+// старше notAfter.
+func (g *GormDatabase) TouchAPIKey(id uint, at, notAfter time.Time) error {
+	return g.Conn.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, notAfter).
+		Update("last_used_at", at).Error
+}
+
 type User struct {
 	gorm.Model
 	Username string `json:"username" gorm:"unique"`
 	Password string `json:"password"`
+	Role     string `json:"role" gorm:"not null;default:member"`
+
+	DisplayName string  `json:"display_name"`
+	Email       *string `json:"email" gorm:"uniqueIndex"`
+
+	// TOTPSecret заполняется при начале подключения 2FA, а действует
+	// только после подтверждения (TOTPEnabled). TOTPLastStep — последний
+	// принятый шаг TOTP, защита от повторного предъявления кода.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"-"`
+	TOTPLastStep int64  `json:"-"`
+
+	// Пользователи, пришедшие через OpenID Connect, связаны с провайдером
+	// по (OIDCIssuer, OIDCSubject) и пароля не имеют. OIDCAuthAt — когда
+	// пользователь последний раз прошёл проверку у провайдера; вместо пароля
+	// подтверждает удаление учётной записи.
+	OIDCIssuer  *string    `json:"-" gorm:"uniqueIndex:idx_users_oidc_subject"`
+	OIDCSubject *string    `json:"-" gorm:"uniqueIndex:idx_users_oidc_subject"`
+	OIDCAuthAt  *time.Time `json:"-"`
 }
 
 func register(c *gin.Context) {
@@ -61,8 +441,24 @@ func register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	errs := validateUsername(user.Username)
+	errs = append(errs, policy.validate(user.Password, user.Username)...)
+	if len(errs) > 0 {
+		respondInvalidFields(c, "Invalid registration", errs)
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Hash password error: %v", err)
+		return
+	}
 	user.Password = string(hashedPassword)
+	// Роль при регистрации не выбирается, её назначает администратор;
+	// имя и email задаются потом через PATCH /me
+	user.Role = roleMember
+	user.DisplayName = ""
+	user.Email = nil
 	if err := db.CreateUser(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
 		return
@@ -76,31 +472,92 @@ func login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Заблокированный ключ отклоняем до проверки пароля, иначе перебор
+	// продолжался бы, просто получая 429 вместо 401
+	ip := c.ClientIP()
+	keys := loginKeys(user.Username, ip)
+	until, err := loginBlockedUntil(keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check login attempts error: %v", err)
+		return
+	}
+	if until.After(now()) {
+		recordLoginFailure(keys, user.Username, ip, failureLocked)
+		tooManyAttempts(c, until)
+		return
+	}
+
 	dbUser, err := db.FindUserByUsername(user.Username)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password)) != nil || dbUser.ID == 0 {
+		recordLoginFailure(keys, user.Username, ip, failureInvalidCredentials)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": dbUser.ID,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte("secret"))
+	// Счётчик неудач сбросится только после второго фактора, иначе,
+	// зная пароль, можно было бы перебирать коды без ограничений
+	if dbUser.TOTPEnabled {
+		startChallenge(c, dbUser)
+		return
+	}
+	completeLogin(c, dbUser, keys)
+}
+
+// completeLogin выдаёт токены после успешной проверки всех факторов.
+func completeLogin(c *gin.Context, user *User, keys []loginKey) {
+	// Счётчик IP не сбрасываем: иначе перебор чужих паролей можно было бы
+	// перемежать входами в собственную учётную запись
+	if err := attempts.Reset(keys[0].key); err != nil {
+		log.Printf("Reset login attempts error: %v", err)
+	}
+	tokens, err := issueTokens(user, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	c.JSON(http.StatusOK, tokens)
+}
+
+// trustedProxies читает TRUSTED_PROXIES — адреса или подсети балансировщиков
+// через запятую.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
 }
 
 func main() {
+	keys, err := loadKeyring()
+	if err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
+	}
+	jwtKeys = keys
+
+	loadTokenTTLs()
+	loadPasswordPolicy()
+	loadLoginLimits()
+	loadRevocationConfig()
+	loadAccountConfig()
+	loadPasswordResetConfig()
+	sso = loadOIDCConfig()
 	initDB()
+	bootstrapAdmin()
 	r := gin.Default()
+	// Без этого ClientIP доверяет X-Forwarded-For от кого угодно, и лимит
+	// по IP обходится подделкой заголовка
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Добавление CORS middleware
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -109,5 +566,33 @@ func main() {
 
 	r.POST("/register", register)
 	r.POST("/login", login)
+	r.POST("/token/refresh", refreshTokens)
+	r.POST("/logout", logout)
+	r.POST("/revoke", revokeToken)
+	r.GET("/revoked", requireInternalKey(), listRevoked)
+	r.GET("/.well-known/jwks.json", getJWKS)
+	r.POST("/login/2fa", loginSecondFactor)
+	r.POST("/password/forgot", forgotPassword)
+	r.POST("/password/reset", resetPassword)
+	if sso != nil {
+		r.GET("/oidc/login", oidcLogin)
+		r.GET("/oidc/callback", oidcCallback)
+	}
+	r.GET("/me", requireUser(), getMe)
+	r.PATCH("/me", requireUser(), updateMe)
+	r.PUT("/me/password", requireUser(), changePassword)
+	r.DELETE("/me", requireUser(), deleteMe)
+	r.POST("/2fa/enroll", requireUser(), enrollTOTP)
+	r.POST("/2fa/confirm", requireUser(), confirmTOTP)
+	r.POST("/2fa/recovery-codes", requireUser(), regenerateRecoveryCodes)
+	r.POST("/2fa/disable", requireUser(), disableTOTP)
+	r.PUT("/users/:id/role", requireUser(), requireAdmin(), setUserRole)
+	r.POST("/service-accounts", requireUser(), requireAdmin(), createServiceAccount)
+	r.GET("/service-accounts", requireUser(), requireAdmin(), listServiceAccounts)
+	r.DELETE("/service-accounts/:id", requireUser(), requireAdmin(), deleteServiceAccount)
+	r.POST("/service-accounts/:id/keys", requireUser(), requireAdmin(), createAPIKey)
+	r.GET("/service-accounts/:id/keys", requireUser(), requireAdmin(), listAPIKeys)
+	r.DELETE("/service-accounts/:id/keys/:key_id", requireUser(), requireAdmin(), revokeAPIKey)
+	r.POST("/internal/api-keys/verify", requireInternalKey(), verifyAPIKey)
 	r.Run(":8081")
 }
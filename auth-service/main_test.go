@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -29,18 +34,249 @@ func (m *MockDatabase) FindUserByUsername(username string) (*User, error) {
 	return args.Get(0).(*User), args.Error(1)
 }
 
+func (m *MockDatabase) FindUserByID(id uint) (*User, error) {
+	args := m.Called(id)
+	user, _ := args.Get(0).(*User)
+	return user, args.Error(1)
+}
+
+func (m *MockDatabase) SetUserRole(id uint, role string) error {
+	args := m.Called(id, role)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GrantFirstAdmin(id uint) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) CreateRefreshToken(token *RefreshToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) FindRefreshToken(hash string) (*RefreshToken, error) {
+	args := m.Called(hash)
+	token, _ := args.Get(0).(*RefreshToken)
+	return token, args.Error(1)
+}
+
+func (m *MockDatabase) MarkRefreshTokenUsed(id uint, at time.Time) (bool, error) {
+	args := m.Called(id, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) RevokeRefreshFamily(familyID string, at time.Time) error {
+	args := m.Called(familyID, at)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) RevokeToken(token *RevokedToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ListRevokedTokens(since, now time.Time) ([]RevokedToken, error) {
+	args := m.Called(since, now)
+	return args.Get(0).([]RevokedToken), args.Error(1)
+}
+
+func (m *MockDatabase) CreateFailedLogin(entry *FailedLogin) error {
+	args := m.Called(entry)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) IsTokenRevoked(jti string) (bool, error) {
+	args := m.Called(jti)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) SetTOTPSecret(userID uint, secret string) error {
+	args := m.Called(userID, secret)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) EnableTOTP(userID uint, step int64, recoveryHashes []string) error {
+	args := m.Called(userID, step, recoveryHashes)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) DisableTOTP(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) MarkTOTPStepUsed(userID uint, step int64) (bool, error) {
+	args := m.Called(userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	args := m.Called(userID, hashes)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) UseRecoveryCode(userID uint, hash string, at time.Time) (bool, error) {
+	args := m.Called(userID, hash, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) CreateLoginChallenge(challenge *LoginChallenge) error {
+	args := m.Called(challenge)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) FindLoginChallenge(hash string) (*LoginChallenge, error) {
+	args := m.Called(hash)
+	challenge, _ := args.Get(0).(*LoginChallenge)
+	return challenge, args.Error(1)
+}
+
+func (m *MockDatabase) IncrementChallengeAttempts(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) DeleteLoginChallenge(id uint) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) UpdateProfile(userID uint, displayName string, email *string) error {
+	args := m.Called(userID, displayName, email)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) UpdatePassword(userID uint, hash string) error {
+	args := m.Called(userID, hash)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) RevokeUserSessions(userID uint, exceptFamilyID string, at time.Time) error {
+	args := m.Called(userID, exceptFamilyID, at)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) DeleteUser(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CreatePasswordReset(reset *PasswordReset, notBefore time.Time) (bool, error) {
+	args := m.Called(reset, notBefore)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) FindPasswordReset(hash string) (*PasswordReset, error) {
+	args := m.Called(hash)
+	reset, _ := args.Get(0).(*PasswordReset)
+	return reset, args.Error(1)
+}
+
+func (m *MockDatabase) MarkPasswordResetUsed(id uint, at time.Time) (bool, error) {
+	args := m.Called(id, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) FindUserByOIDCSubject(issuer, subject string) (*User, error) {
+	args := m.Called(issuer, subject)
+	user, _ := args.Get(0).(*User)
+	return user, args.Error(1)
+}
+
+func (m *MockDatabase) SetOIDCAuthTime(userID uint, at time.Time) error {
+	args := m.Called(userID, at)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CreateOIDCLogin(login *OIDCLogin) error {
+	args := m.Called(login)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) TakeOIDCLogin(stateHash string) (*OIDCLogin, error) {
+	args := m.Called(stateHash)
+	if take, ok := args.Get(0).(func(string) (*OIDCLogin, error)); ok {
+		return take(stateHash)
+	}
+	login, _ := args.Get(0).(*OIDCLogin)
+	return login, args.Error(1)
+}
+
+func (m *MockDatabase) CreateServiceAccount(account *ServiceAccount) error {
+	args := m.Called(account)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) FindServiceAccount(id uint) (*ServiceAccount, error) {
+	args := m.Called(id)
+	account, _ := args.Get(0).(*ServiceAccount)
+	return account, args.Error(1)
+}
+
+func (m *MockDatabase) ListServiceAccounts() ([]ServiceAccount, error) {
+	args := m.Called()
+	accounts, _ := args.Get(0).([]ServiceAccount)
+	return accounts, args.Error(1)
+}
+
+func (m *MockDatabase) DeleteServiceAccount(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CreateAPIKey(key *APIKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ListAPIKeys(accountID uint) ([]APIKey, error) {
+	args := m.Called(accountID)
+	keys, _ := args.Get(0).([]APIKey)
+	return keys, args.Error(1)
+}
+
+func (m *MockDatabase) FindAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	args := m.Called(prefix)
+	key, _ := args.Get(0).(*APIKey)
+	return key, args.Error(1)
+}
+
+func (m *MockDatabase) RevokeAPIKey(accountID, keyID uint, at time.Time) (bool, error) {
+	args := m.Called(accountID, keyID, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) TouchAPIKey(id uint, at, notAfter time.Time) error {
+	args := m.Called(id, at, notAfter)
+	return args.Error(0)
+}
+
+func TestMain(m *testing.M) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	jwtKeys = newKeyring()
+	jwtKeys.add("test", key)
+	attempts = newMemoryAttemptStore()
+	os.Exit(m.Run())
+}
+
 func TestRegister(t *testing.T) {
 	gin.SetMode(gin.TestMode) // Устанавливаем режим тестирования для gin
 
 	r := gin.Default()
-	mockDB := new(MockDatabase)
-	db = mockDB
-
 	r.POST("/register", register)
 
 	t.Run("successful registration", func(t *testing.T) {
-		user := &User{Username: "testuser", Password: "password"}
-		mockDB.On("CreateUser", mock.AnythingOfType("*main.User")).Return(nil)
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		user := &User{Username: "testuser", Password: "correct-horse-42"}
+		mockDB.On("CreateUser", mock.MatchedBy(func(user *User) bool {
+			return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("correct-horse-42")) == nil
+		})).Return(nil)
 
 		jsonUser, _ := json.Marshal(user)
 		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(jsonUser))
@@ -54,7 +290,10 @@ func TestRegister(t *testing.T) {
 	})
 
 	t.Run("register with existing username", func(t *testing.T) {
-		user := &User{Username: "testuser", Password: "password"}
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		user := &User{Username: "testuser", Password: "correct-horse-42"}
 		mockDB.On("CreateUser", mock.AnythingOfType("*main.User")).Return(gorm.ErrDuplicatedKey)
 
 		jsonUser, _ := json.Marshal(user)
@@ -67,21 +306,73 @@ func TestRegister(t *testing.T) {
 		assert.JSONEq(t, `{"error": "Username already exists"}`, resp.Body.String())
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("role in request is ignored", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("CreateUser", mock.MatchedBy(func(user *User) bool {
+			return user.Role == roleMember
+		})).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodPost, "/register",
+			bytes.NewBufferString(`{"username": "testuser", "password": "correct-horse-42", "role": "admin"}`))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("invalid credentials return field errors", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(`{"username": "", "password": ""}`))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "Invalid registration", "fields": [
+			{"field": "username", "message": "is required"},
+			{"field": "password", "message": "is required"}
+		]}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "CreateUser", mock.Anything)
+	})
+
+	t.Run("password longer than bcrypt accepts", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		body, _ := json.Marshal(gin.H{"username": "testuser", "password": strings.Repeat("Ab1", 30)})
+		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(body))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "must not be longer than 72 bytes")
+		mockDB.AssertNotCalled(t, "CreateUser", mock.Anything)
+	})
 }
 
 func TestLogin(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	r := gin.Default()
-	mockDB := new(MockDatabase)
-	db = mockDB
-
 	r.POST("/login", login)
 
 	t.Run("successful login", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 		user := &User{Username: "testuser", Password: string(hashedPassword)}
+		user.ID = 1
 		mockDB.On("FindUserByUsername", "testuser").Return(user, nil)
+		mockDB.On("CreateRefreshToken", mock.AnythingOfType("*main.RefreshToken")).Return(nil)
 
 		loginCredentials := &User{Username: "testuser", Password: "password"}
 		jsonCredentials, _ := json.Marshal(loginCredentials)
@@ -91,15 +382,23 @@ func TestLogin(t *testing.T) {
 		r.ServeHTTP(resp, req)
 
 		assert.Equal(t, http.StatusOK, resp.Code)
-		var response map[string]string
+		var response map[string]interface{}
 		json.Unmarshal(resp.Body.Bytes(), &response)
 		assert.Contains(t, response, "token")
+		assert.Contains(t, response, "refresh_token")
 		mockDB.AssertExpectations(t)
 	})
 
 	t.Run("login with invalid credentials", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
 		user := &User{Username: "testuser", Password: "wronghash"}
+		user.ID = 1
 		mockDB.On("FindUserByUsername", "testuser").Return(user, nil)
+		mockDB.On("CreateFailedLogin", mock.MatchedBy(func(entry *FailedLogin) bool {
+			return entry.Username == "testuser" && entry.Reason == failureInvalidCredentials
+		})).Return(nil)
 
 		loginCredentials := &User{Username: "testuser", Password: "wrongpassword"}
 		jsonCredentials, _ := json.Marshal(loginCredentials)
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// oidcLoginTTL — сколько ждём возвращения пользователя от провайдера.
+	oidcLoginTTL    = 10 * time.Minute
+	oidcStateCookie = "oidc_state"
+	// oidcKeysMinRefresh не даёт токенам с выдуманным kid заваливать
+	// провайдера запросами.
+	oidcKeysMinRefresh = 30 * time.Second
+)
+
+// oidcConfig — настройки входа через внешний OpenID Connect провайдер.
+type oidcConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// SuccessURL — страница клиента, куда после входа отправляются токены
+	// (во фрагменте URL, чтобы они не попадали в логи). Без неё /oidc/callback
+	// отвечает JSON, как /login.
+	SuccessURL string
+}
+
+// oidcMetadata — нужная нам часть документа discovery провайдера.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider загружает discovery и ключи провайдера при первом обращении,
+// поэтому auth-service запускается, даже если провайдер временно недоступен.
+type oidcProvider struct {
+	oidcConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *oidcMetadata
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// sso равен nil, если вход через провайдера не настроен.
+var sso *oidcProvider
+
+func newOIDCProvider(cfg oidcConfig) *oidcProvider {
+	return &oidcProvider{
+		oidcConfig: cfg,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		keys:       map[string]*rsa.PublicKey{},
+	}
+}
+
+// loadOIDCConfig читает OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL, OIDC_SCOPES и OIDC_SUCCESS_URL. Без OIDC_ISSUER вход
+// через провайдера выключен.
+func loadOIDCConfig() *oidcProvider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	cfg := oidcConfig{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		SuccessURL:   os.Getenv("OIDC_SUCCESS_URL"),
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		log.Fatal("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	return newOIDCProvider(cfg)
+}
+
+func (p *oidcProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *oidcProvider) discover() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	var m oidcMetadata
+	if err := p.getJSON(strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	// Провайдер обязан назвать себя ровно так, как мы его настроили
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// keyFor возвращает ключ провайдера по "kid" ID-токена. Неизвестный kid
+// означает ротацию ключей, и набор перезагружается.
+func (p *oidcProvider) keyFor(token *jwt.Token) (interface{}, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) > oidcKeysMinRefresh {
+		var body struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := p.getJSON(metadata.JWKSURI, &body); err != nil {
+			return nil, err
+		}
+		keys := make(map[string]*rsa.PublicKey, len(body.Keys))
+		for _, k := range body.Keys {
+			if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+				continue
+			}
+			pub, err := k.publicKey()
+			if err != nil {
+				return nil, fmt.Errorf("jwks: key %q: %w", k.Kid, err)
+			}
+			keys[k.Kid] = pub
+		}
+		p.keys = keys
+		p.keysFetchedAt = time.Now()
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// authorizationURL строит адрес, на который отправляется браузер.
+func (p *oidcProvider) authorizationURL(state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return metadata.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// pkceChallenge — S256-преобразование code_verifier по RFC 7636.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// exchange обменивает код авторизации на ID-токен.
+func (p *oidcProvider) exchange(code, verifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	// Публичный клиент (без секрета) защищён только PKCE
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: status %d: %s", resp.StatusCode, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// oidcIdentity — проверенные утверждения ID-токена.
+type oidcIdentity struct {
+	Subject           string
+	PreferredUsername string
+	Name              string
+	Email             string
+	EmailVerified     bool
+	// AuthTime — когда пользователь прошёл проверку у провайдера (auth_time);
+	// нулевое, если провайдер его не сообщил.
+	AuthTime time.Time
+}
+
+// verifyIDToken проверяет подпись, издателя, аудиторию, срок и nonce
+// ID-токена (OpenID Connect Core, 3.1.3.7).
+func (p *oidcProvider) verifyIDToken(raw, nonce string) (*oidcIdentity, error) {
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	if _, err := parser.ParseWithClaims(raw, claims, p.keyFor); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	audience := audienceOf(claims["aud"])
+	if !containsString(audience, p.ClientID) {
+		return nil, errors.New("token is not intended for this client")
+	}
+	if azp, ok := claims["azp"].(string); (ok || len(audience) > 1) && azp != p.ClientID {
+		return nil, errors.New("token was issued to another party")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	identity := &oidcIdentity{Subject: sub}
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		identity.AuthTime = time.Unix(int64(authTime), 0)
+	}
+	return identity, nil
+}
+
+// audienceOf разбирает "aud", который может быть строкой или массивом.
+func audienceOf(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCLogin хранит state, nonce и code_verifier между /oidc/login и
+// /oidc/callback. Ищется по хэшу state.
+type OIDCLogin struct {
+	gorm.Model
+	StateHash    string `gorm:"uniqueIndex"`
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// oidcLogin — GET /oidc/login, отправляет браузер к провайдеру. State
+// дублируется в cookie: ответ провайдера принимается только в том же
+// браузере, иначе можно было бы подсунуть жертве вход в чужую учётную запись.
+func oidcLogin(c *gin.Context) {
+	var state, nonce, verifier string
+	for _, v := range []*string{&state, &nonce, &verifier} {
+		token, err := randomToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Generate OIDC state error: %v", err)
+			return
+		}
+		*v = token
+	}
+
+	target, err := sso.authorizationURL(state, nonce, verifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		log.Printf("OIDC discovery error: %v", err)
+		return
+	}
+	if err := db.CreateOIDCLogin(&OIDCLogin{
+		StateHash:    hashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now().Add(oidcLoginTTL),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Create OIDC login error: %v", err)
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcLoginTTL.Seconds()), "/oidc", "",
+		strings.HasPrefix(sso.RedirectURL, "https://"), true)
+	c.Redirect(http.StatusFound, target)
+}
+
+// oidcCallback — GET /oidc/callback, сюда провайдер возвращает браузер с
+// кодом авторизации. Ответ — токены или, при включённой 2FA, вызов, как
+// у /login.
+func oidcCallback(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider denied the login", "reason": e})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/oidc", "", strings.HasPrefix(sso.RedirectURL, "https://"), true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+	// Запись удаляется при чтении, поэтому один state нельзя использовать дважды
+	login, err := db.TakeOIDCLogin(hashToken(state))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Take OIDC login error: %v", err)
+		}
+		return
+	}
+	if now().After(login.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	rawIDToken, err := sso.exchange(c.Query("code"), login.CodeVerifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not complete login with the identity provider"})
+		log.Printf("OIDC code exchange error: %v", err)
+		return
+	}
+	identity, err := sso.verifyIDToken(rawIDToken, login.Nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		log.Printf("OIDC ID token error: %v", err)
+		return
+	}
+
+	user, err := provisionOIDCUser(sso.Issuer, identity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Provision OIDC user error: %v", err)
+		return
+	}
+	authAt := identity.AuthTime
+	if authAt.IsZero() || authAt.After(now()) {
+		authAt = now()
+	}
+	if err := db.SetOIDCAuthTime(user.ID, authAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Set OIDC auth time error: %v", err)
+		return
+	}
+	// Провайдер заменяет только пароль: с включённой 2FA вместо токенов
+	// выдаётся вызов, который обменивается на них в POST /login/2fa
+	var response gin.H
+	if user.TOTPEnabled {
+		response, err = newLoginChallenge(user)
+	} else {
+		response, err = issueTokens(user, "")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Complete OIDC login error: %v", err)
+		return
+	}
+	if sso.SuccessURL == "" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	fragment := url.Values{}
+	for k, v := range response {
+		fragment.Set(k, fmt.Sprint(v))
+	}
+	c.Redirect(http.StatusFound, sso.SuccessURL+"#"+fragment.Encode())
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// oidcUsername подбирает локальное имя пользователя по утверждениям
+// провайдера. Имя нужно только для отображения: связь с провайдером
+// держится на subject.
+func oidcUsername(identity *oidcIdentity) string {
+	candidates := []string{identity.PreferredUsername}
+	if at := strings.IndexByte(identity.Email, '@'); at > 0 {
+		candidates = append(candidates, identity.Email[:at])
+	}
+	for _, name := range candidates {
+		name = strings.Trim(usernameUnsafe.ReplaceAllString(name, "-"), "._-")
+		// Оставляем место для суффикса на случай, если имя занято
+		if len(name) > maxUsernameLength-6 {
+			name = name[:maxUsernameLength-6]
+		}
+		if len(validateUsername(name)) == 0 {
+			return name
+		}
+	}
+	return "user"
+}
+
+// provisionOIDCUser находит пользователя по (issuer, subject) или создаёт
+// его при первом входе. Пароля у такого пользователя нет, поэтому войти
+// через /login он не может. Email берётся, только если провайдер его
+// подтвердил; с существующими учётными записями по email не связываем,
+// иначе провайдер, разрешающий любой адрес, позволил бы захватить чужую.
+func provisionOIDCUser(issuer string, identity *oidcIdentity) (*User, error) {
+	user, err := db.FindUserByOIDCSubject(issuer, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	username := oidcUsername(identity)
+	var email *string
+	if identity.EmailVerified && identity.Email != "" {
+		email = &identity.Email
+	}
+	name := strings.Join(strings.Fields(identity.Name), " ")
+	if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		name = string([]rune(name)[:maxDisplayNameLength])
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		subject := identity.Subject
+		user := &User{
+			Username:    username,
+			Role:        roleMember,
+			DisplayName: name,
+			Email:       email,
+			OIDCIssuer:  &issuer,
+			OIDCSubject: &subject,
+		}
+		err := db.CreateUser(user)
+		if err == nil {
+			log.Printf("Provisioned user %q for OIDC subject %q", user.Username, identity.Subject)
+			return user, nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, err
+		}
+		// Возможно, пользователя только что создал параллельный вход
+		if existing, err := db.FindUserByOIDCSubject(issuer, identity.Subject); err == nil {
+			return existing, nil
+		}
+		// Иначе занято имя или email: email не берём, к имени добавляем суффикс
+		suffix, err := newTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		username = oidcUsername(identity) + "-" + strings.ToLower(suffix[:5])
+		email = nil
+	}
+	return nil, errors.New("could not pick a free username")
+}
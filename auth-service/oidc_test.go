@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fakeIdP — минимальный OpenID Connect провайдер: сразу «логинит»
+// пользователя в /authorize и проверяет PKCE в /token.
+type fakeIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	// codes хранит code_challenge и nonce выданных кодов
+	codes map[string]url.Values
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp := &fakeIdP{key: key, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	idp.claims = jwt.MapClaims{
+		"iss":                idp.URL,
+		"aud":                "booking",
+		"sub":                "idp-user-1",
+		"preferred_username": "Alice Smith",
+		"name":               "Alice Smith",
+		"email":              "alice@corp.example",
+		"email_verified":     true,
+	}
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(gin.H{"keys": []jwk{{
+			Kty: "RSA", Use: "sig", Alg: "RS256", Kid: "idp",
+			N: base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := "code-" + q.Get("state")[:8]
+		idp.codes[code] = q
+		back := url.Values{"code": {code}, "state": {q.Get("state")}}
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		auth, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("redirect_uri") != auth.Get("redirect_uri") ||
+			r.PostForm.Get("client_id") != auth.Get("client_id") ||
+			pkceChallenge(r.PostForm.Get("code_verifier")) != auth.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(gin.H{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{"nonce": auth.Get("nonce"), "iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "idp"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(gin.H{"access_token": "opaque", "token_type": "Bearer", "id_token": signed})
+	})
+	return idp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/oidc/login", oidcLogin)
+	r.GET("/oidc/callback", oidcCallback)
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	setup := func(t *testing.T) (*fakeIdP, *MockDatabase) {
+		idp := newFakeIdP(t)
+		saved := sso
+		t.Cleanup(func() { sso = saved })
+		sso = newOIDCProvider(oidcConfig{
+			Issuer:      idp.URL,
+			ClientID:    "booking",
+			RedirectURL: "http://auth.local/oidc/callback",
+			Scopes:      []string{"openid", "email"},
+		})
+
+		mockDB := new(MockDatabase)
+		db = mockDB
+		var stored *OIDCLogin
+		mockDB.On("CreateOIDCLogin", mock.Anything).
+			Run(func(args mock.Arguments) { stored = args.Get(0).(*OIDCLogin) }).
+			Return(nil)
+		mockDB.On("TakeOIDCLogin", mock.Anything).Return(func(hash string) (*OIDCLogin, error) {
+			if stored == nil || stored.StateHash != hash {
+				return nil, gorm.ErrRecordNotFound
+			}
+			login := stored
+			stored = nil
+			return login, nil
+		})
+		mockDB.On("CreateRefreshToken", mock.Anything).Return(nil)
+		mockDB.On("SetOIDCAuthTime", mock.Anything, mock.Anything).Return(nil).Maybe()
+		return idp, mockDB
+	}
+
+	// start проходит /oidc/login и провайдера и возвращает запрос к
+	// /oidc/callback вместе с cookie state.
+	start := func(t *testing.T) (*http.Request, url.Values) {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/oidc/login", nil)
+		r.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusFound, resp.Code)
+		authorize, _ := url.Parse(resp.Header().Get("Location"))
+
+		idpResp, err := noRedirects.Get(authorize.String())
+		require.NoError(t, err)
+		idpResp.Body.Close()
+		back, _ := url.Parse(idpResp.Header.Get("Location"))
+
+		callback, _ := http.NewRequest(http.MethodGet, "/oidc/callback?"+back.RawQuery, nil)
+		for _, cookie := range resp.Result().Cookies() {
+			callback.AddCookie(cookie)
+		}
+		return callback, authorize.Query()
+	}
+	finish := func(req *http.Request) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("first login provisions a user linked by subject", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return((*User)(nil), gorm.ErrRecordNotFound)
+		var created *User
+		mockDB.On("CreateUser", mock.Anything).
+			Run(func(args mock.Arguments) {
+				created = args.Get(0).(*User)
+				created.ID = 5
+			}).
+			Return(nil)
+
+		callback, authorize := start(t)
+		assert.Equal(t, "S256", authorize.Get("code_challenge_method"))
+		assert.NotEmpty(t, authorize.Get("code_challenge"))
+		assert.Equal(t, "openid email", authorize.Get("scope"))
+		resp := finish(callback)
+
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.Contains(t, resp.Body.String(), `"refresh_token"`)
+		assert.Equal(t, "Alice-Smith", created.Username)
+		assert.Equal(t, "Alice Smith", created.DisplayName)
+		assert.Equal(t, "alice@corp.example", *created.Email)
+		assert.Equal(t, roleMember, created.Role)
+		assert.Empty(t, created.Password)
+		assert.Equal(t, "idp-user-1", *created.OIDCSubject)
+		assert.Equal(t, idp.URL, *created.OIDCIssuer)
+	})
+
+	t.Run("returning user is found by subject", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		user := &User{Username: "alice", Role: roleAdmin}
+		user.ID = 5
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return(user, nil)
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		mockDB.AssertNotCalled(t, "CreateUser", mock.Anything)
+		var body map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		parsed, _ := jwt.Parse(body["token"].(string), func(*jwt.Token) (interface{}, error) {
+			_, key := jwtKeys.signingKey()
+			return &key.PublicKey, nil
+		})
+		claims := parsed.Claims.(jwt.MapClaims)
+		assert.Equal(t, float64(5), claims["user_id"])
+		assert.Equal(t, roleAdmin, claims["role"])
+	})
+
+	t.Run("login time from the provider is recorded", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+		idp.claims["auth_time"] = authTime.Unix()
+		user := &User{Username: "alice"}
+		user.ID = 5
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return(user, nil)
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		mockDB.AssertCalled(t, "SetOIDCAuthTime", uint(5), mock.MatchedBy(authTime.Equal))
+	})
+
+	t.Run("user with 2FA gets a challenge instead of tokens", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		user := &User{Username: "alice", TOTPEnabled: true, TOTPSecret: "secret"}
+		user.ID = 5
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return(user, nil)
+		mockDB.On("CreateLoginChallenge", mock.MatchedBy(func(ch *LoginChallenge) bool { return ch.UserID == 5 })).Return(nil)
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var body map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		assert.Equal(t, true, body["two_factor_required"])
+		assert.NotEmpty(t, body["challenge_token"])
+		assert.NotContains(t, body, "token")
+		mockDB.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+
+	t.Run("taken username gets a suffix and unverified email is dropped", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		idp.claims["email_verified"] = false
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return((*User)(nil), gorm.ErrRecordNotFound)
+		mockDB.On("CreateUser", mock.MatchedBy(func(u *User) bool { return u.Username == "Alice-Smith" })).
+			Return(gorm.ErrDuplicatedKey).Once()
+		var created *User
+		mockDB.On("CreateUser", mock.Anything).
+			Run(func(args mock.Arguments) { created = args.Get(0).(*User) }).
+			Return(nil)
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.True(t, strings.HasPrefix(created.Username, "Alice-Smith-"), created.Username)
+		assert.Empty(t, validateUsername(created.Username))
+		assert.Nil(t, created.Email)
+	})
+
+	t.Run("callback without the state cookie is rejected", func(t *testing.T) {
+		setup(t)
+
+		callback, _ := start(t)
+		callback.Header.Del("Cookie")
+		resp := finish(callback)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("state cannot be used twice", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		user := &User{Username: "alice"}
+		user.ID = 5
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return(user, nil)
+
+		callback, _ := start(t)
+		require.Equal(t, http.StatusOK, finish(callback).Code)
+		resp := finish(callback)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("token for another client is rejected", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		idp.claims["aud"] = []string{"other-client"}
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertNotCalled(t, "FindUserByOIDCSubject", mock.Anything, mock.Anything)
+	})
+
+	t.Run("token from another issuer is rejected", func(t *testing.T) {
+		idp, _ := setup(t)
+		idp.claims["iss"] = "https://evil.example"
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("provider error is reported", func(t *testing.T) {
+		setup(t)
+
+		resp := finish(httptest.NewRequest(http.MethodGet, "/oidc/callback?error=access_denied", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Body.String(), "access_denied")
+	})
+
+	t.Run("success URL receives tokens in the fragment", func(t *testing.T) {
+		idp, mockDB := setup(t)
+		sso.SuccessURL = "http://localhost:3000/"
+		user := &User{Username: "alice"}
+		user.ID = 5
+		mockDB.On("FindUserByOIDCSubject", idp.URL, "idp-user-1").Return(user, nil)
+
+		callback, _ := start(t)
+		resp := finish(callback)
+
+		require.Equal(t, http.StatusFound, resp.Code)
+		location, _ := url.Parse(resp.Header().Get("Location"))
+		fragment, _ := url.ParseQuery(location.Fragment)
+		assert.Equal(t, "localhost:3000", location.Host)
+		assert.Empty(t, location.RawQuery)
+		assert.NotEmpty(t, fragment.Get("token"))
+		assert.NotEmpty(t, fragment.Get("refresh_token"))
+	})
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Пример из RFC 7636, приложение B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestOIDCUsername(t *testing.T) {
+	assert.Equal(t, "jdoe", oidcUsername(&oidcIdentity{PreferredUsername: "jdoe"}))
+	assert.Equal(t, "john.doe", oidcUsername(&oidcIdentity{PreferredUsername: "Джон", Email: "john.doe@corp.example"}))
+	assert.Equal(t, "user", oidcUsername(&oidcIdentity{PreferredUsername: "x"}))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// PasswordReset — одноразовый токен сброса пароля. Как и refresh-токены,
+// хранится только хэш.
+type PasswordReset struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// passwordResetTTL переопределяется PASSWORD_RESET_TTL. passwordResetCooldown
+// не даёт засыпать пользователя письмами: пока предыдущий токен свежий,
+// новый не выпускается.
+var (
+	passwordResetTTL      = 30 * time.Minute
+	passwordResetCooldown = time.Minute
+	notificationURL       string
+	// passwordResetURL — страница клиента, к которой дописывается токен.
+	passwordResetURL   = "http://localhost:3000/reset-password"
+	notificationClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func loadPasswordResetConfig() {
+	if v := os.Getenv("PASSWORD_RESET_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid PASSWORD_RESET_TTL: %q", v)
+		}
+		passwordResetTTL = d
+	}
+	if v := os.Getenv("PASSWORD_RESET_URL"); v != "" {
+		passwordResetURL = v
+	}
+	notificationURL = strings.TrimRight(os.Getenv("NOTIFICATION_URL"), "/")
+	if notificationURL != "" && internalAPIKey == "" {
+		log.Fatalf("NOTIFICATION_URL requires INTERNAL_API_KEY")
+	}
+}
+
+// Ответ /password/forgot одинаков для существующих и несуществующих
+// пользователей, чтобы по нему нельзя было перебирать имена.
+const forgotPasswordResponse = "If the account exists and has an email address, a reset link has been sent"
+
+// forgotPassword — POST /password/forgot. Поиск пользователя, выпуск
+// токена и отправка письма идут в фоне: запрос существующего и
+// несуществующего пользователя проходит один и тот же путь, и время ответа
+// не выдаёт, нашёлся ли пользователь.
+func forgotPassword(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	background(func() { requestPasswordReset(req.Username) })
+	c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordResponse})
+}
+
+// background запускает работу, результат которой не нужен в ответе.
+var background = func(f func()) { go f() }
+
+func requestPasswordReset(username string) {
+	user, err := db.FindUserByUsername(username)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		log.Printf("Password reset requested for unknown user %q", username)
+	case err != nil:
+		log.Printf("Find user error: %v", err)
+	case user.Email == nil:
+		log.Printf("Password reset requested for user %d without email", user.ID)
+	case user.OIDCSubject != nil:
+		// Паролем такого пользователя управляет провайдер входа
+		log.Printf("Password reset requested for SSO user %d", user.ID)
+	default:
+		if err := startPasswordReset(user); err != nil {
+			log.Printf("Start password reset error: %v", err)
+		}
+	}
+}
+
+func startPasswordReset(user *User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	at := now()
+	created, err := db.CreatePasswordReset(&PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: at.Add(passwordResetTTL),
+	}, at.Add(-passwordResetCooldown))
+	if err != nil {
+		return err
+	}
+	if !created {
+		log.Printf("Password reset for user %d throttled", user.ID)
+		return nil
+	}
+	deliverPasswordReset(*user.Email, token)
+	return nil
+}
+
+func deliverPasswordReset(email, token string) {
+	link := passwordResetURL + "?token=" + url.QueryEscape(token)
+	payload := gin.H{"link": link, "ttl": passwordResetTTL.String()}
+	if err := notify(email, "password_reset", payload); err != nil {
+		log.Printf("Deliver password reset: %v", err)
+	}
+}
+
+// notify ставит письмо по шаблону в очередь notification-service. Ответ
+// 202 означает, что уведомление принято, а доставляется оно в фоне.
+var notify = func(recipient, template string, payload gin.H) error {
+	if notificationURL == "" {
+		return errors.New("NOTIFICATION_URL is not set")
+	}
+	body, _ := json.Marshal(gin.H{"recipient": recipient, "channel": "email", "template": template, "payload": payload})
+	req, err := http.NewRequest(http.MethodPost, notificationURL+"/notify", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+internalAPIKey)
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("notification-service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type passwordResetRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password"`
+}
+
+// resetPassword — POST /password/reset. Токен гасится только после проверки
+// нового пароля, чтобы слабый пароль не сжигал ссылку. После сброса все
+// сеансы завершаются, а блокировка входа снимается.
+func resetPassword(c *gin.Context) {
+	var req passwordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reset, err := db.FindPasswordReset(hashToken(req.Token))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find password reset error: %v", err)
+		return
+	}
+	if err != nil || reset.UsedAt != nil || now().After(reset.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	user, err := db.FindUserByID(reset.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find user error: %v", err)
+		}
+		return
+	}
+	if errs := policy.validate(req.NewPassword, user.Username); len(errs) > 0 {
+		for i := range errs {
+			errs[i].Field = "new_password"
+		}
+		respondInvalidFields(c, "Invalid password", errs)
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Hash password error: %v", err)
+		return
+	}
+
+	// Условное обновление: из двух запросов с одним токеном пройдёт один
+	consumed, err := db.MarkPasswordResetUsed(reset.ID, now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Mark password reset error: %v", err)
+		return
+	}
+	if !consumed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	if err := db.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Update password error: %v", err)
+		return
+	}
+	if err := db.RevokeUserSessions(user.ID, "", now()); err != nil {
+		log.Printf("Revoke sessions error: %v", err)
+	}
+	if err := attempts.Reset(loginKeys(user.Username, "")[0].key); err != nil {
+		log.Printf("Reset login attempts error: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
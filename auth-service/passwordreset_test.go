@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestPasswordReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/password/forgot", forgotPassword)
+	r.POST("/password/reset", resetPassword)
+
+	fixed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	type sent struct {
+		recipient, template string
+		payload             gin.H
+	}
+	outbox := make(chan sent, 1)
+	// Фоновая работа выполняется сразу, чтобы не пережить подтест
+	background = func(f func()) { f() }
+	defer func() { background = func(f func()) { go f() } }()
+	notify = func(recipient, template string, payload gin.H) error {
+		outbox <- sent{recipient, template, payload}
+		return nil
+	}
+
+	email := "alice@example.com"
+	newUser := func() *User {
+		user := &User{Username: "alice", Password: "old-hash", Email: &email}
+		user.ID = 1
+		return user
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("forgot sends a link with a token whose hash is stored", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("FindUserByUsername", "alice").Return(newUser(), nil)
+		var stored *PasswordReset
+		mockDB.On("CreatePasswordReset", mock.Anything, fixed.Add(-passwordResetCooldown)).
+			Run(func(args mock.Arguments) { stored = args.Get(0).(*PasswordReset) }).
+			Return(true, nil)
+
+		resp := post("/password/forgot", `{"username": "alice"}`)
+
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.Contains(t, resp.Body.String(), forgotPasswordResponse)
+		select {
+		case msg := <-outbox:
+			assert.Equal(t, email, msg.recipient)
+			assert.Equal(t, "password_reset", msg.template)
+			assert.Equal(t, passwordResetTTL.String(), msg.payload["ttl"])
+			link, _ := msg.payload["link"].(string)
+			i := strings.Index(link, "?token=")
+			if assert.True(t, i >= 0) {
+				token := link[i+len("?token="):]
+				assert.Equal(t, hashToken(token), stored.TokenHash)
+				assert.NotContains(t, stored.TokenHash, token)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("reset link was not sent")
+		}
+		assert.Equal(t, uint(1), stored.UserID)
+		assert.Equal(t, fixed.Add(passwordResetTTL), stored.ExpiresAt)
+	})
+
+	t.Run("forgot gives the same answer for unknown users", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("FindUserByUsername", "alice").Return(newUser(), nil)
+		mockDB.On("CreatePasswordReset", mock.Anything, mock.Anything).Return(true, nil)
+		mockDB.On("FindUserByUsername", "mallory").Return((*User)(nil), gorm.ErrRecordNotFound)
+
+		known := post("/password/forgot", `{"username": "alice"}`)
+		<-outbox
+		unknown := post("/password/forgot", `{"username": "mallory"}`)
+
+		assert.Equal(t, known.Code, unknown.Code)
+		assert.Equal(t, known.Body.String(), unknown.Body.String())
+	})
+
+	t.Run("forgot answers before looking the user up", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		var pending []func()
+		background = func(f func()) { pending = append(pending, f) }
+		defer func() { background = func(f func()) { f() } }()
+
+		resp := post("/password/forgot", `{"username": "alice"}`)
+
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.Len(t, pending, 1)
+		// До ответа база не затрагивается, поэтому путь одинаков для всех
+		mockDB.AssertNotCalled(t, "FindUserByUsername", mock.Anything)
+	})
+
+	t.Run("forgot does nothing for users without email", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		user := newUser()
+		user.Email = nil
+		mockDB.On("FindUserByUsername", "alice").Return(user, nil)
+
+		resp := post("/password/forgot", `{"username": "alice"}`)
+
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "CreatePasswordReset", mock.Anything, mock.Anything)
+	})
+
+	t.Run("reset sets the password and ends all sessions", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		reset := &PasswordReset{UserID: 1, ExpiresAt: fixed.Add(time.Minute)}
+		reset.ID = 7
+		mockDB.On("FindPasswordReset", hashToken("reset-token")).Return(reset, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(newUser(), nil)
+		mockDB.On("MarkPasswordResetUsed", uint(7), fixed).Return(true, nil)
+		var newHash string
+		mockDB.On("UpdatePassword", uint(1), mock.Anything).
+			Run(func(args mock.Arguments) { newHash = args.String(1) }).
+			Return(nil)
+		mockDB.On("RevokeUserSessions", uint(1), "", fixed).Return(nil)
+		attempts.Fail("user:alice", fixed, fixed.Add(-time.Hour), usernameLimit)
+
+		resp := post("/password/reset", `{"token": "reset-token", "new_password": "brand-new-pass-7"}`)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(newHash), []byte("brand-new-pass-7")))
+		mockDB.AssertCalled(t, "RevokeUserSessions", uint(1), "", fixed)
+		state, _ := attempts.Get("user:alice")
+		assert.Zero(t, state.Failures)
+	})
+
+	t.Run("reset rejects unknown, used and expired tokens", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		usedAt := fixed.Add(-time.Minute)
+		used := &PasswordReset{UserID: 1, ExpiresAt: fixed.Add(time.Minute), UsedAt: &usedAt}
+		expired := &PasswordReset{UserID: 1, ExpiresAt: fixed.Add(-time.Second)}
+		mockDB.On("FindPasswordReset", hashToken("unknown")).Return((*PasswordReset)(nil), gorm.ErrRecordNotFound)
+		mockDB.On("FindPasswordReset", hashToken("used")).Return(used, nil)
+		mockDB.On("FindPasswordReset", hashToken("expired")).Return(expired, nil)
+
+		for _, token := range []string{"unknown", "used", "expired"} {
+			resp := post("/password/reset", `{"token": "`+token+`", "new_password": "brand-new-pass-7"}`)
+
+			assert.Equal(t, http.StatusBadRequest, resp.Code, token)
+			assert.JSONEq(t, `{"error": "Invalid or expired reset token"}`, resp.Body.String(), token)
+		}
+		mockDB.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything)
+	})
+
+	t.Run("weak password does not consume the token", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		reset := &PasswordReset{UserID: 1, ExpiresAt: fixed.Add(time.Minute)}
+		mockDB.On("FindPasswordReset", hashToken("reset-token")).Return(reset, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(newUser(), nil)
+
+		resp := post("/password/reset", `{"token": "reset-token", "new_password": "short"}`)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "new_password")
+		mockDB.AssertNotCalled(t, "MarkPasswordResetUsed", mock.Anything, mock.Anything)
+	})
+
+	t.Run("token consumed by a concurrent request is rejected", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		reset := &PasswordReset{UserID: 1, ExpiresAt: fixed.Add(time.Minute)}
+		reset.ID = 7
+		mockDB.On("FindPasswordReset", hashToken("reset-token")).Return(reset, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(newUser(), nil)
+		mockDB.On("MarkPasswordResetUsed", uint(7), fixed).Return(false, nil)
+
+		resp := post("/password/reset", `{"token": "reset-token", "new_password": "brand-new-pass-7"}`)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything)
+	})
+}
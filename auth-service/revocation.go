@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RevokedToken — отозванный access-токен. Запись нужна только до истечения
+// токена, после этого он и так недействителен.
+type RevokedToken struct {
+	gorm.Model
+	JTI       string    `json:"jti" gorm:"uniqueIndex"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+}
+
+// revocationPush — адреса сервисов, которым сразу сообщаем об отзыве.
+// Это ускоряет распространение; гарантию даёт периодическая выгрузка GET /revoked.
+var (
+	revocationPushURLs []string
+	internalAPIKey     string
+	pushClient         = &http.Client{Timeout: 5 * time.Second}
+)
+
+func loadRevocationConfig() {
+	for _, url := range strings.Split(os.Getenv("REVOCATION_PUSH_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			revocationPushURLs = append(revocationPushURLs, url)
+		}
+	}
+	internalAPIKey = os.Getenv("INTERNAL_API_KEY")
+	if len(revocationPushURLs) > 0 && internalAPIKey == "" {
+		log.Fatalf("REVOCATION_PUSH_URLS requires INTERNAL_API_KEY")
+	}
+}
+
+// parseAccessToken проверяет подпись токена, выданного этим сервисом.
+// Истёкший токен возвращается вместе с ошибкой, чтобы вызывающий мог это различить.
+func parseAccessToken(tokenStr string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := jwtKeys.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return &key.PublicKey, nil
+	})
+	return claims, err
+}
+
+func isExpired(err error) bool {
+	var ve *jwt.ValidationError
+	return errors.As(err, &ve) && ve.Errors == jwt.ValidationErrorExpired
+}
+
+// revokeAccessToken вносит jti токена в список отзыва. Истёкший токен
+// отзывать незачем, это не ошибка.
+func revokeAccessToken(tokenStr string) error {
+	claims, err := parseAccessToken(tokenStr)
+	if isExpired(err) {
+		return nil
+	}
+	if err != nil {
+		return errInvalidToken
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" || exp == 0 {
+		return errInvalidToken
+	}
+
+	revoked := RevokedToken{JTI: jti, ExpiresAt: time.Unix(int64(exp), 0).UTC()}
+	if err := db.RevokeToken(&revoked); err != nil {
+		return err
+	}
+	go pushRevocation(revoked)
+	return nil
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+func pushRevocation(revoked RevokedToken) {
+	body, _ := json.Marshal(gin.H{"jti": revoked.JTI, "expires_at": revoked.ExpiresAt})
+	for _, url := range revocationPushURLs {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Push revocation to %s: %v", url, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+internalAPIKey)
+		resp, err := pushClient.Do(req)
+		if err != nil {
+			log.Printf("Push revocation to %s: %v", url, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("Push revocation to %s: status %d", url, resp.StatusCode)
+		}
+	}
+}
+
+// revokeToken — POST /revoke. Отозвать можно любой действительный токен,
+// выданный этим сервисом: кто знает токен, тот может им и воспользоваться.
+func revokeToken(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := revokeAccessToken(req.Token); err != nil {
+		if errors.Is(err, errInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Revoke token error: %v", err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+// revokedListOverlap — насколько раньше since начинается выгрузка. Отзыв
+// получает created_at до фиксации транзакции и может стать видимым уже
+// после того, как клиент получил более поздний since. Повторно выданные
+// записи клиенту не мешают.
+const revokedListOverlap = time.Minute
+
+// listRevoked — GET /revoked?since=RFC3339. Отдаёт ещё не истёкшие отзывы,
+// внесённые после since (с запасом revokedListOverlap), и серверное время
+// для следующего запроса. Доступ проверяет requireInternalKey.
+func listRevoked(c *gin.Context) {
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since"})
+			return
+		}
+		since = t.Add(-revokedListOverlap)
+	}
+
+	now := time.Now().UTC()
+	tokens, err := db.ListRevokedTokens(since, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("List revoked tokens error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": tokens, "now": now})
+}
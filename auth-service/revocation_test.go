@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func issueTestToken(t *testing.T) string {
+	mockDB := new(MockDatabase)
+	db = mockDB
+	mockDB.On("CreateRefreshToken", mock.Anything).Return(nil)
+
+	user := &User{Role: roleMember}
+	user.ID = 1
+	tokens, err := issueTokens(user, "")
+	require.NoError(t, err)
+	return tokens["token"].(string)
+}
+
+func TestRevokeToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/revoke", revokeToken)
+
+	revoke := func(token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(gin.H{"token": token})
+		req, _ := http.NewRequest(http.MethodPost, "/revoke", bytes.NewBuffer(body))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("revokes token by jti", func(t *testing.T) {
+		token := issueTestToken(t)
+		claims, err := parseAccessToken(token)
+		require.NoError(t, err)
+
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("RevokeToken", mock.MatchedBy(func(revoked *RevokedToken) bool {
+			return revoked.JTI == claims["jti"] && revoked.ExpiresAt.After(time.Now())
+		})).Return(nil)
+
+		resp := revoke(token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"message": "Token revoked"}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("forged token", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := revoke("not.a.token")
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertNotCalled(t, "RevokeToken", mock.Anything)
+	})
+}
+
+func TestPushRevocation(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer server.Close()
+
+	savedURLs, savedKey := revocationPushURLs, internalAPIKey
+	defer func() { revocationPushURLs, internalAPIKey = savedURLs, savedKey }()
+	revocationPushURLs, internalAPIKey = []string{server.URL}, "key"
+
+	pushRevocation(RevokedToken{JTI: "j1", ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+	body := <-received
+	assert.Equal(t, "j1", body["jti"])
+	assert.Equal(t, "2030-01-01T00:00:00Z", body["expires_at"])
+}
+
+func TestListRevoked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	savedKey := internalAPIKey
+	defer func() { internalAPIKey = savedKey }()
+	internalAPIKey = "key"
+
+	r := gin.Default()
+	r.GET("/revoked", requireInternalKey(), listRevoked)
+
+	mockDB := new(MockDatabase)
+	db = mockDB
+	// Отзывы, зафиксированные позже своего created_at, попадают в выгрузку
+	since := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Add(-revokedListOverlap)
+	mockDB.On("ListRevokedTokens", since, mock.Anything).Return([]RevokedToken{{JTI: "j1"}}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/revoked?since=2030-01-01T00:00:00Z", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	req.Header.Set("Authorization", "Bearer key")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"jti":"j1"`)
+	mockDB.AssertExpectations(t)
+
+	// Без INTERNAL_API_KEY список недоступен, а не открыт всем
+	internalAPIKey = ""
+	req.Header.Set("Authorization", "Bearer ")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	mockDB.AssertNumberOfCalls(t, "ListRevokedTokens", 1)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Роли пользователей. Роль попадает в access-токен, а booking-service по ней
+// решает, что пользователю разрешено.
+const (
+	roleAdmin       = "admin"
+	roleMember      = "member"
+	roleRoomManager = "room-manager"
+)
+
+func validRole(role string) bool {
+	switch role {
+	case roleAdmin, roleMember, roleRoomManager:
+		return true
+	}
+	return false
+}
+
+// bootstrapAdmin назначает первого администратора — пользователя с ID из
+// ADMIN_USER_ID. Имя для этого не годится: его может занять кто угодно
+// через /register или вход через провайдера. Назначение срабатывает, только
+// пока администраторов нет, поэтому снятую роль перезапуск не вернёт.
+func bootstrapAdmin() {
+	if os.Getenv("ADMIN_USERNAMES") != "" {
+		log.Printf("ADMIN_USERNAMES is ignored, set ADMIN_USER_ID to appoint the first admin")
+	}
+	v := os.Getenv("ADMIN_USER_ID")
+	if v == "" {
+		return
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || id == 0 {
+		log.Fatalf("invalid ADMIN_USER_ID: %q", v)
+	}
+	granted, err := db.GrantFirstAdmin(uint(id))
+	if err != nil {
+		log.Printf("Bootstrap admin %d: %v", id, err)
+		return
+	}
+	if granted {
+		log.Printf("Granted admin role to user %d", id)
+	}
+}
+
+// requireAdmin пропускает только администраторов. Ставится после requireUser,
+// поэтому роль берётся из базы, а не из токена: отобранные права перестают
+// действовать сразу, не дожидаясь истечения токена.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentUser(c).Role != roleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setUserRole — PUT /users/:id/role, только для администраторов. Новая роль
+// попадёт в токены пользователя при следующем входе или обновлении.
+func setUserRole(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	// Последний администратор не должен случайно лишить себя прав
+	admin := currentUser(c)
+	if admin.ID == uint(id) && req.Role != roleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot revoke your own admin role"})
+		return
+	}
+
+	if err := db.SetUserRole(uint(id), req.Role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Set user role error: %v", err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "role": req.Role})
+}
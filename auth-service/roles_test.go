@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestSetUserRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.PUT("/users/:id/role", requireUser(), requireAdmin(), setUserRole)
+
+	token := issueTestToken(t)
+	admin := &User{Username: "root", Role: roleAdmin}
+	admin.ID = 1
+
+	put := func(path, body, auth string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPut, path, bytes.NewBufferString(body))
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("admin assigns role", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(admin, nil)
+		mockDB.On("SetUserRole", uint(2), roleRoomManager).Return(nil)
+
+		resp := put("/users/2/role", `{"role": "room-manager"}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"id": 2, "role": "room-manager"}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("member is forbidden", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		member := &User{Username: "bob", Role: roleMember}
+		member.ID = 1
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(member, nil)
+
+		resp := put("/users/2/role", `{"role": "admin"}`, token)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "SetUserRole", mock.Anything, mock.Anything)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		db = new(MockDatabase)
+
+		resp := put("/users/2/role", `{"role": "admin"}`, "")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(admin, nil)
+
+		resp := put("/users/2/role", `{"role": "superuser"}`, token)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "Unknown role"}`, resp.Body.String())
+	})
+
+	t.Run("admin cannot demote themselves", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(admin, nil)
+
+		resp := put("/users/1/role", `{"role": "member"}`, token)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertNotCalled(t, "SetUserRole", mock.Anything, mock.Anything)
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(admin, nil)
+		mockDB.On("SetUserRole", uint(99), roleMember).Return(gorm.ErrRecordNotFound)
+
+		resp := put("/users/99/role", `{"role": "member"}`, token)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("self-registered admin does not get the role", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("CreateUser", mock.MatchedBy(func(u *User) bool {
+			return u.Username == "admin" && u.Role == roleMember
+		})).Return(nil)
+
+		r := gin.Default()
+		r.POST("/register", register)
+		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(`{"username": "admin", "password": "correct-horse-42"}`))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		t.Setenv("ADMIN_USERNAMES", "admin")
+		t.Setenv("ADMIN_USER_ID", "")
+		bootstrapAdmin()
+
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "FindUserByUsername", mock.Anything)
+		mockDB.AssertNotCalled(t, "SetUserRole", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "GrantFirstAdmin", mock.Anything)
+	})
+
+	t.Run("configured user ID becomes the first admin", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("GrantFirstAdmin", uint(7)).Return(true, nil)
+
+		t.Setenv("ADMIN_USER_ID", "7")
+		bootstrapAdmin()
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("existing admin is not overruled", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		// Администратор уже есть: GrantFirstAdmin ничего не меняет
+		mockDB.On("GrantFirstAdmin", uint(7)).Return(false, nil)
+
+		t.Setenv("ADMIN_USER_ID", "7")
+		bootstrapAdmin()
+
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "SetUserRole", mock.Anything, mock.Anything)
+	})
+}
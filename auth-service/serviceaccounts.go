@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Области действия API-ключей. Ключи дают доступ только на чтение: брони в
+// booking-service принадлежат пользователям, а не сервисным учётным записям.
+const (
+	scopeBookingsRead = "bookings:read"
+	scopeRoomsRead    = "rooms:read"
+)
+
+func validScope(scope string) bool {
+	switch scope {
+	case scopeBookingsRead, scopeRoomsRead:
+		return true
+	}
+	return false
+}
+
+const (
+	// apiKeyMarker начинает каждый ключ, чтобы его было легко узнать в
+	// конфигурации и логах, а booking-service мог отличить его от JWT.
+	apiKeyMarker    = "bks_"
+	apiKeyPrefixLen = 8
+	// apiKeyDefaultTTL — срок ключа, если при создании он не указан.
+	apiKeyDefaultTTL = 90 * 24 * time.Hour
+	apiKeyMaxTTL     = 365 * 24 * time.Hour
+	// lastUsedPrecision — last_used_at обновляется не чаще, чтобы частые
+	// запросы табло не писали в базу на каждый вызов.
+	lastUsedPrecision = time.Minute
+)
+
+// ServiceAccount — учётная запись для программ: табло у переговорных,
+// скриптов. Войти через /login она не может, только владеет API-ключами.
+type ServiceAccount struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Description string `json:"description"`
+	CreatedBy   uint   `json:"created_by"`
+}
+
+// APIKey — ключ сервисной учётной записи. Ключ имеет вид
+// bks_<prefix>_<secret>: по prefix ключ находится и показывается в списках,
+// а от secret хранится только хэш.
+type APIKey struct {
+	gorm.Model
+	ServiceAccountID uint `gorm:"index"`
+	Name             string
+	Prefix           string `gorm:"uniqueIndex"`
+	SecretHash       string
+	// Scopes — области действия через пробел
+	Scopes     string
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+func (k *APIKey) scopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// view — ключ в ответах API, без хэша секрета.
+func (k *APIKey) view() gin.H {
+	return gin.H{
+		"id":                 k.ID,
+		"service_account_id": k.ServiceAccountID,
+		"name":               k.Name,
+		"prefix":             k.Prefix,
+		"scopes":             k.scopeList(),
+		"created_at":         k.CreatedAt,
+		"expires_at":         k.ExpiresAt,
+		"last_used_at":       k.LastUsedAt,
+		"revoked_at":         k.RevokedAt,
+	}
+}
+
+// newAPIKey возвращает ключ для показа и его префикс и хэш секрета для базы.
+func newAPIKey() (key, prefix, secretHash string, err error) {
+	raw, err := newTOTPSecret()
+	if err != nil {
+		return "", "", "", err
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	prefix = strings.ToLower(raw[:apiKeyPrefixLen])
+	return apiKeyMarker + prefix + "_" + secret, prefix, hashToken(secret), nil
+}
+
+// parseAPIKey разбирает ключ на префикс и секрет.
+func parseAPIKey(key string) (prefix, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyMarker)
+	if !ok || len(rest) < apiKeyPrefixLen+2 || rest[apiKeyPrefixLen] != '_' {
+		return "", "", false
+	}
+	return rest[:apiKeyPrefixLen], rest[apiKeyPrefixLen+1:], true
+}
+
+// serviceAccountFromParam загружает учётную запись по :id. При ошибке отвечает сам.
+func serviceAccountFromParam(c *gin.Context) (*ServiceAccount, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return nil, false
+	}
+	account, err := db.FindServiceAccount(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find service account error: %v", err)
+		}
+		return nil, false
+	}
+	return account, true
+}
+
+// createServiceAccount — POST /service-accounts, только для администраторов.
+func createServiceAccount(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Имя учётной записи подчиняется тем же правилам, что и имя пользователя
+	if errs := validateUsername(req.Name); len(errs) > 0 {
+		errs[0].Field = "name"
+		respondInvalidFields(c, "Invalid service account", errs)
+		return
+	}
+
+	account := ServiceAccount{Name: req.Name, Description: strings.TrimSpace(req.Description), CreatedBy: currentUser(c).ID}
+	if err := db.CreateServiceAccount(&account); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Service account already exists"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Create service account error: %v", err)
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"service_account": account})
+}
+
+// listServiceAccounts — GET /service-accounts.
+func listServiceAccounts(c *gin.Context) {
+	accounts, err := db.ListServiceAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("List service accounts error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service_accounts": accounts})
+}
+
+// deleteServiceAccount — DELETE /service-accounts/:id. Ключи учётной записи
+// удаляются вместе с ней.
+func deleteServiceAccount(c *gin.Context) {
+	account, ok := serviceAccountFromParam(c)
+	if !ok {
+		return
+	}
+	if err := db.DeleteServiceAccount(account.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Delete service account error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Service account deleted"})
+}
+
+type apiKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (r *apiKeyRequest) validate() []fieldError {
+	var errs []fieldError
+	if len(r.Scopes) == 0 {
+		errs = append(errs, fieldError{"scopes", "must not be empty"})
+	}
+	for _, scope := range r.Scopes {
+		if !validScope(scope) {
+			errs = append(errs, fieldError{"scopes", "unknown scope " + strconv.Quote(scope)})
+		}
+	}
+	if r.ExpiresAt != nil && (!r.ExpiresAt.After(now()) || r.ExpiresAt.After(now().Add(apiKeyMaxTTL))) {
+		errs = append(errs, fieldError{"expires_at", "must be in the future and within a year"})
+	}
+	return errs
+}
+
+// createAPIKey — POST /service-accounts/:id/keys. Ключ показывается
+// единственный раз, в ответе.
+func createAPIKey(c *gin.Context) {
+	account, ok := serviceAccountFromParam(c)
+	if !ok {
+		return
+	}
+	var req apiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errs := req.validate(); len(errs) > 0 {
+		respondInvalidFields(c, "Invalid API key", errs)
+		return
+	}
+
+	key, prefix, secretHash, err := newAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Generate API key error: %v", err)
+		return
+	}
+	apiKey := APIKey{
+		ServiceAccountID: account.ID,
+		Name:             req.Name,
+		Prefix:           prefix,
+		SecretHash:       secretHash,
+		Scopes:           strings.Join(req.Scopes, " "),
+		ExpiresAt:        now().Add(apiKeyDefaultTTL),
+	}
+	if req.ExpiresAt != nil {
+		apiKey.ExpiresAt = *req.ExpiresAt
+	}
+	if err := db.CreateAPIKey(&apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Create API key error: %v", err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"api_key": apiKey.view(), "key": key})
+}
+
+// listAPIKeys — GET /service-accounts/:id/keys, включая отозванные и истёкшие.
+func listAPIKeys(c *gin.Context) {
+	account, ok := serviceAccountFromParam(c)
+	if !ok {
+		return
+	}
+	keys, err := db.ListAPIKeys(account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("List API keys error: %v", err)
+		return
+	}
+	out := make([]gin.H, len(keys))
+	for i := range keys {
+		out[i] = keys[i].view()
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": out})
+}
+
+// revokeAPIKey — DELETE /service-accounts/:id/keys/:key_id. Запись
+// остаётся, чтобы по префиксу из логов можно было понять, чей это был ключ.
+func revokeAPIKey(c *gin.Context) {
+	account, ok := serviceAccountFromParam(c)
+	if !ok {
+		return
+	}
+	keyID, err := strconv.ParseUint(c.Param("key_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+	revoked, err := db.RevokeAPIKey(account.ID, uint(keyID), now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Revoke API key error: %v", err)
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// verifyAPIKey — POST /internal/api-keys/verify, вызывается booking-service.
+// Отвечает учётной записью и областями действия ключа; причину отказа не
+// раскрывает.
+func verifyAPIKey(c *gin.Context) {
+	var req struct {
+		Key string `json:"key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invalid := func() { c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"}) }
+	prefix, secret, ok := parseAPIKey(req.Key)
+	if !ok {
+		invalid()
+		return
+	}
+	key, err := db.FindAPIKeyByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			invalid()
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find API key error: %v", err)
+		}
+		return
+	}
+	at := now()
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.SecretHash)) != 1 ||
+		key.RevokedAt != nil || !at.Before(key.ExpiresAt) {
+		invalid()
+		return
+	}
+	account, err := db.FindServiceAccount(key.ServiceAccountID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			invalid()
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find service account error: %v", err)
+		}
+		return
+	}
+
+	if err := db.TouchAPIKey(key.ID, at, at.Add(-lastUsedPrecision)); err != nil {
+		log.Printf("Touch API key error: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"service_account_id": account.ID,
+		"service_account":    account.Name,
+		"key_prefix":         key.Prefix,
+		"scopes":             key.scopeList(),
+		"expires_at":         key.ExpiresAt,
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestServiceAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/service-accounts", requireUser(), requireAdmin(), createServiceAccount)
+	r.POST("/service-accounts/:id/keys", requireUser(), requireAdmin(), createAPIKey)
+	r.GET("/service-accounts/:id/keys", requireUser(), requireAdmin(), listAPIKeys)
+	r.DELETE("/service-accounts/:id/keys/:key_id", requireUser(), requireAdmin(), revokeAPIKey)
+
+	fixed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	token := issueTestToken(t)
+	account := &ServiceAccount{Name: "lobby-display"}
+	account.ID = 3
+	newDB := func(role string) *MockDatabase {
+		user := &User{Username: "root", Role: role}
+		user.ID = 1
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil)
+		mockDB.On("FindUserByID", uint(1)).Return(user, nil)
+		mockDB.On("FindServiceAccount", uint(3)).Return(account, nil)
+		return mockDB
+	}
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("admin creates a service account", func(t *testing.T) {
+		mockDB := newDB(roleAdmin)
+		mockDB.On("CreateServiceAccount", mock.MatchedBy(func(a *ServiceAccount) bool {
+			return a.Name == "lobby-display" && a.CreatedBy == 1
+		})).Return(nil)
+
+		resp := send(http.MethodPost, "/service-accounts", `{"name": "lobby-display", "description": "Screen at the entrance"}`)
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		mockDB.AssertCalled(t, "CreateServiceAccount", mock.Anything)
+	})
+
+	t.Run("members cannot manage service accounts", func(t *testing.T) {
+		mockDB := newDB(roleMember)
+
+		resp := send(http.MethodPost, "/service-accounts", `{"name": "lobby-display"}`)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "CreateServiceAccount", mock.Anything)
+	})
+
+	t.Run("key is shown once and only its hash is stored", func(t *testing.T) {
+		mockDB := newDB(roleAdmin)
+		var stored *APIKey
+		mockDB.On("CreateAPIKey", mock.Anything).
+			Run(func(args mock.Arguments) { stored = args.Get(0).(*APIKey) }).
+			Return(nil)
+
+		resp := send(http.MethodPost, "/service-accounts/3/keys", `{"name": "panel", "scopes": ["bookings:read", "rooms:read"]}`)
+
+		require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+		var body struct {
+			Key    string                 `json:"key"`
+			APIKey map[string]interface{} `json:"api_key"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		prefix, secret, ok := parseAPIKey(body.Key)
+		require.True(t, ok, body.Key)
+		assert.True(t, strings.HasPrefix(body.Key, "bks_"+prefix+"_"))
+		assert.Equal(t, prefix, stored.Prefix)
+		assert.Equal(t, hashToken(secret), stored.SecretHash)
+		assert.NotContains(t, resp.Body.String(), stored.SecretHash)
+		assert.Equal(t, "bookings:read rooms:read", stored.Scopes)
+		assert.Equal(t, fixed.Add(apiKeyDefaultTTL), stored.ExpiresAt)
+		assert.Equal(t, prefix, body.APIKey["prefix"])
+	})
+
+	t.Run("invalid scopes and expiry are rejected", func(t *testing.T) {
+		mockDB := newDB(roleAdmin)
+
+		resp := send(http.MethodPost, "/service-accounts/3/keys",
+			`{"name": "panel", "scopes": ["bookings:write"], "expires_at": "2020-01-01T00:00:00Z"}`)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"scopes"`)
+		assert.Contains(t, resp.Body.String(), `"expires_at"`)
+		mockDB.AssertNotCalled(t, "CreateAPIKey", mock.Anything)
+	})
+
+	t.Run("listing shows usage without secrets", func(t *testing.T) {
+		mockDB := newDB(roleAdmin)
+		used := fixed.Add(-time.Hour)
+		key := APIKey{ServiceAccountID: 3, Name: "panel", Prefix: "abcd2345", SecretHash: "hash",
+			Scopes: "bookings:read", ExpiresAt: fixed.Add(time.Hour), LastUsedAt: &used}
+		mockDB.On("ListAPIKeys", uint(3)).Return([]APIKey{key}, nil)
+
+		resp := send(http.MethodGet, "/service-accounts/3/keys", "")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"prefix":"abcd2345"`)
+		assert.Contains(t, resp.Body.String(), `"scopes":["bookings:read"]`)
+		assert.Contains(t, resp.Body.String(), `"last_used_at":"2026-03-01T11:00:00Z"`)
+		assert.NotContains(t, resp.Body.String(), "hash")
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		mockDB := newDB(roleAdmin)
+		mockDB.On("RevokeAPIKey", uint(3), uint(7), fixed).Return(true, nil)
+		mockDB.On("RevokeAPIKey", uint(3), uint(8), fixed).Return(false, nil)
+
+		assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/service-accounts/3/keys/7", "").Code)
+		assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/service-accounts/3/keys/8", "").Code)
+	})
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	savedKey := internalAPIKey
+	defer func() { internalAPIKey = savedKey }()
+	internalAPIKey = "internal"
+
+	r := gin.Default()
+	r.POST("/internal/api-keys/verify", requireInternalKey(), verifyAPIKey)
+
+	fixed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	account := &ServiceAccount{Name: "lobby-display"}
+	account.ID = 3
+	newDB := func(key *APIKey) *MockDatabase {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("FindAPIKeyByPrefix", "abcd2345").Return(key, nil)
+		mockDB.On("FindAPIKeyByPrefix", mock.Anything).Return((*APIKey)(nil), gorm.ErrRecordNotFound)
+		mockDB.On("FindServiceAccount", uint(3)).Return(account, nil)
+		mockDB.On("TouchAPIKey", uint(7), fixed, fixed.Add(-lastUsedPrecision)).Return(nil)
+		return mockDB
+	}
+	validKey := func() *APIKey {
+		key := &APIKey{ServiceAccountID: 3, Prefix: "abcd2345", SecretHash: hashToken("s3cret"),
+			Scopes: "bookings:read", ExpiresAt: fixed.Add(time.Hour)}
+		key.ID = 7
+		return key
+	}
+	verify := func(key, auth string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(gin.H{"key": key})
+		req, _ := http.NewRequest(http.MethodPost, "/internal/api-keys/verify", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+auth)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("valid key returns its scopes and records use", func(t *testing.T) {
+		mockDB := newDB(validKey())
+
+		resp := verify("bks_abcd2345_s3cret", "internal")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"service_account_id":3`)
+		assert.Contains(t, resp.Body.String(), `"scopes":["bookings:read"]`)
+		mockDB.AssertCalled(t, "TouchAPIKey", uint(7), fixed, fixed.Add(-lastUsedPrecision))
+	})
+
+	t.Run("requires the internal key", func(t *testing.T) {
+		newDB(validKey())
+
+		assert.Equal(t, http.StatusUnauthorized, verify("bks_abcd2345_s3cret", "wrong").Code)
+	})
+
+	t.Run("wrong, revoked and expired keys are rejected", func(t *testing.T) {
+		revokedAt := fixed.Add(-time.Minute)
+		revoked := validKey()
+		revoked.RevokedAt = &revokedAt
+		expired := validKey()
+		expired.ExpiresAt = fixed
+
+		cases := []struct {
+			name string
+			key  *APIKey
+			raw  string
+		}{
+			{"wrong secret", validKey(), "bks_abcd2345_other"},
+			{"unknown prefix", validKey(), "bks_zzzz2345_s3cret"},
+			{"malformed", validKey(), "abcd2345_s3cret"},
+			{"revoked", revoked, "bks_abcd2345_s3cret"},
+			{"expired", expired, "bks_abcd2345_s3cret"},
+		}
+		for _, tc := range cases {
+			mockDB := newDB(tc.key)
+
+			resp := verify(tc.raw, "internal")
+
+			assert.Equal(t, http.StatusUnauthorized, resp.Code, tc.name)
+			mockDB.AssertNotCalled(t, "TouchAPIKey", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+}
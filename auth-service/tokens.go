@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Время жизни токенов; ACCESS_TOKEN_TTL и REFRESH_TOKEN_TTL переопределяют
+// значения по умолчанию.
+var (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// RefreshToken — непрозрачный токен обновления. В базе хранится только хэш.
+// Все токены, полученные цепочкой обновлений от одного входа, составляют
+// семейство (FamilyID): повторное использование любого из них означает утечку,
+// и отзывается всё семейство.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	FamilyID  string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func loadTokenTTLs() {
+	for env, ttl := range map[string]*time.Duration{
+		"ACCESS_TOKEN_TTL":  &accessTokenTTL,
+		"REFRESH_TOKEN_TTL": &refreshTokenTTL,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s: %q", env, v)
+			}
+			*ttl = d
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens выдаёт пару access/refresh. Пустой familyID начинает новое семейство.
+// Каждый access-токен получает уникальный jti, по которому его можно отозвать.
+func issueTokens(user *User, familyID string) (gin.H, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	if familyID == "" {
+		if familyID, err = randomToken(); err != nil {
+			return nil, err
+		}
+	}
+	// sid — семейство refresh-токенов, то есть сеанс, к которому относится
+	// access-токен. По нему смена пароля отличает текущий сеанс от остальных.
+	accessToken, err := jwtKeys.sign(jwt.MapClaims{
+		"user_id": user.ID,
+		"role":    user.Role,
+		"sid":     familyID,
+		"jti":     jti,
+		"iat":     time.Now().Unix(),
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateRefreshToken(&RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// refreshTokens обменивает refresh-токен на новую пару. Старый токен
+// помечается использованным; если его предъявят снова, отзываем семейство.
+func refreshTokens(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stored, err := db.FindRefreshToken(hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find refresh token error: %v", err)
+		}
+		return
+	}
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	// Условное обновление: из двух одновременных запросов с одним токеном
+	// пройдёт только один, второй будет считаться повторным использованием
+	fresh := stored.UsedAt == nil
+	if fresh {
+		fresh, err = db.MarkRefreshTokenUsed(stored.ID, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Mark refresh token error: %v", err)
+			return
+		}
+	}
+	if !fresh {
+		if err := db.RevokeRefreshFamily(stored.FamilyID, time.Now()); err != nil {
+			log.Printf("Revoke refresh family error: %v", err)
+		}
+		log.Printf("Refresh token reuse detected for user %d, family revoked", stored.UserID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected"})
+		return
+	}
+
+	// Пользователя читаем заново, чтобы в новый токен попала актуальная роль
+	user, err := db.FindUserByID(stored.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find user error: %v", err)
+		}
+		return
+	}
+
+	tokens, err := issueTokens(user, stored.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Issue tokens error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// logout отзывает семейство, к которому относится refresh-токен, и текущий
+// access-токен из заголовка Authorization, если он передан. Ответ не
+// зависит от того, найден ли refresh-токен.
+func logout(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stored, err := db.FindRefreshToken(hashToken(req.RefreshToken))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find refresh token error: %v", err)
+		return
+	}
+	if stored != nil {
+		if err := db.RevokeRefreshFamily(stored.FamilyID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Revoke refresh family error: %v", err)
+			return
+		}
+	}
+	if accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		if err := revokeAccessToken(accessToken); err != nil && !errors.Is(err, errInvalidToken) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Revoke token error: %v", err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
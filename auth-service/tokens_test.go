@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/token/refresh", refreshTokens)
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/token/refresh", bytes.NewBufferString(`{"refresh_token": "`+token+`"}`))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("rotates refresh token within the family", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		stored := &RefreshToken{UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour)}
+		stored.ID = 5
+		mockDB.On("FindRefreshToken", hashToken("old-token")).Return(stored, nil)
+		mockDB.On("MarkRefreshTokenUsed", uint(5), mock.Anything).Return(true, nil)
+		user := &User{Username: "testuser", Role: roleRoomManager}
+		user.ID = 1
+		mockDB.On("FindUserByID", uint(1)).Return(user, nil)
+		mockDB.On("CreateRefreshToken", mock.MatchedBy(func(token *RefreshToken) bool {
+			return token.UserID == 1 && token.FamilyID == "fam" && token.TokenHash != hashToken("old-token")
+		})).Return(nil)
+
+		resp := refresh("old-token")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		assert.Contains(t, response, "token")
+		assert.NotEqual(t, "old-token", response["refresh_token"])
+		assert.Equal(t, float64(accessTokenTTL.Seconds()), response["expires_in"])
+		claims, err := parseAccessToken(response["token"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, roleRoomManager, claims["role"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("reuse revokes the whole family", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		usedAt := time.Now().Add(-time.Minute)
+		stored := &RefreshToken{UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt}
+		mockDB.On("FindRefreshToken", mock.Anything).Return(stored, nil)
+		mockDB.On("RevokeRefreshFamily", "fam", mock.Anything).Return(nil)
+
+		resp := refresh("old-token")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.JSONEq(t, `{"error": "Refresh token reuse detected"}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+
+	t.Run("concurrent use loses the race", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		stored := &RefreshToken{UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour)}
+		mockDB.On("FindRefreshToken", mock.Anything).Return(stored, nil)
+		mockDB.On("MarkRefreshTokenUsed", mock.Anything, mock.Anything).Return(false, nil)
+		mockDB.On("RevokeRefreshFamily", "fam", mock.Anything).Return(nil)
+
+		resp := refresh("old-token")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("expired or revoked token", func(t *testing.T) {
+		revokedAt := time.Now()
+		for _, stored := range []*RefreshToken{
+			{FamilyID: "fam", ExpiresAt: time.Now().Add(-time.Minute)},
+			{FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &revokedAt},
+		} {
+			mockDB := new(MockDatabase)
+			db = mockDB
+			mockDB.On("FindRefreshToken", mock.Anything).Return(stored, nil)
+
+			resp := refresh("old-token")
+
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.JSONEq(t, `{"error": "Invalid refresh token"}`, resp.Body.String())
+		}
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("FindRefreshToken", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+
+		resp := refresh("nope")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/logout", logout)
+
+	mockDB := new(MockDatabase)
+	db = mockDB
+	mockDB.On("FindRefreshToken", hashToken("token")).Return(&RefreshToken{FamilyID: "fam"}, nil)
+	mockDB.On("RevokeRefreshFamily", "fam", mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest(http.MethodPost, "/logout", bytes.NewBufferString(`{"refresh_token": "token"}`))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"message": "Logged out"}`, resp.Body.String())
+	mockDB.AssertExpectations(t)
+}
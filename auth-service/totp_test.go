@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Контрольные значения SHA1 из приложения B RFC 6238, последние 6 цифр.
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		assert.Equal(t, want, totpCode(key, totpStep(time.Unix(unix, 0))), "t=%d", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	at := time.Unix(1111111109, 0)
+	step := totpStep(at)
+
+	got, ok := verifyTOTP(secret, totpCode(key, step), at, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// Соседние шаги допускаются, более далёкие — нет
+	_, ok = verifyTOTP(secret, totpCode(key, step-1), at, 0)
+	assert.True(t, ok)
+	_, ok = verifyTOTP(secret, totpCode(key, step+2), at, 0)
+	assert.False(t, ok)
+
+	// Уже использованный шаг отклоняется
+	_, ok = verifyTOTP(secret, totpCode(key, step), at, step)
+	assert.False(t, ok)
+
+	_, ok = verifyTOTP(secret, "12345", at, 0)
+	assert.False(t, ok)
+}
+
+func TestOtpauthURI(t *testing.T) {
+	secret, err := newTOTPSecret()
+	require.NoError(t, err)
+
+	u, err := url.Parse(otpauthURI("alice", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Booking Service:alice", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "Booking Service", u.Query().Get("issuer"))
+	assert.False(t, strings.Contains(secret, "="))
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	// challengeTTL — сколько живёт вызов между паролем и кодом.
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+)
+
+// RecoveryCode — одноразовый код на случай потери аутентификатора.
+// Как и refresh-токены, хранится только хэш.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"uniqueIndex"`
+	UsedAt   *time.Time
+}
+
+// LoginChallenge выдаётся /login вместо токенов, если включена 2FA, и
+// обменивается на токены в POST /login/2fa. Это не JWT: booking-service
+// принял бы подписанный нами токен за обычный access-токен.
+type LoginChallenge struct {
+	gorm.Model
+	UserID    uint
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+// newRecoveryCodes возвращает коды для показа пользователю и их хэши для базы.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		secret, err := newTOTPSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// checkSecondFactor принимает либо текущий TOTP-код, либо неиспользованный
+// код восстановления. Оба становятся недействительными после успешной проверки.
+func checkSecondFactor(user *User, code string) (bool, error) {
+	code = normalizeCode(code)
+	if len(code) == totpDigits {
+		step, ok := verifyTOTP(user.TOTPSecret, code, now(), user.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		return db.MarkTOTPStepUsed(user.ID, step)
+	}
+	return db.UseRecoveryCode(user.ID, hashToken(code), now())
+}
+
+// codeAttemptKey — счётчик неверных кодов в /2fa/*. Эти запросы уже
+// аутентифицированы access-токеном, поэтому ключ — ID пользователя, а
+// порог тот же, что у неудачных входов по имени.
+func codeAttemptKey(user *User) []loginKey {
+	return []loginKey{{"totp:" + strconv.FormatUint(uint64(user.ID), 10), usernameLimit}}
+}
+
+// codeAttemptsBlocked отвечает 429, если неверных кодов было слишком много.
+func codeAttemptsBlocked(c *gin.Context, user *User, keys []loginKey) bool {
+	until, err := loginBlockedUntil(keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check code attempts error: %v", err)
+		return true
+	}
+	if until.After(now()) {
+		recordLoginFailure(keys, user.Username, c.ClientIP(), failureLocked)
+		tooManyAttempts(c, until)
+		return true
+	}
+	return false
+}
+
+// startChallenge отвечает на /login, когда пароль верен, но нужен второй фактор.
+func startChallenge(c *gin.Context, user *User) {
+	challenge, err := newLoginChallenge(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Create login challenge error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, challenge)
+}
+
+// newLoginChallenge сохраняет вызов второго фактора и возвращает ответ
+// клиенту вместо токенов.
+func newLoginChallenge(user *User) (gin.H, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateLoginChallenge(&LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now().Add(challengeTTL),
+	}); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          int(challengeTTL.Seconds()),
+	}, nil
+}
+
+type secondFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// loginSecondFactor — POST /login/2fa. После maxChallengeAttempts неверных
+// кодов вызов сгорает и нужно снова ввести пароль; неверные коды к тому же
+// учитываются в лимите неудачных входов пользователя.
+func loginSecondFactor(c *gin.Context) {
+	var req secondFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenge, err := db.FindLoginChallenge(hashToken(req.ChallengeToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find login challenge error: %v", err)
+		}
+		return
+	}
+	if now().After(challenge.ExpiresAt) || challenge.Attempts >= maxChallengeAttempts {
+		if _, err := db.DeleteLoginChallenge(challenge.ID); err != nil {
+			log.Printf("Delete login challenge error: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+
+	user, err := db.FindUserByID(challenge.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find user error: %v", err)
+		return
+	}
+
+	ok, err := checkSecondFactor(user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check second factor error: %v", err)
+		return
+	}
+	ip := c.ClientIP()
+	keys := loginKeys(user.Username, ip)
+	if !ok {
+		if err := db.IncrementChallengeAttempts(challenge.ID); err != nil {
+			log.Printf("Increment challenge attempts error: %v", err)
+		}
+		recordLoginFailure(keys, user.Username, ip, failureInvalidCode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// Удаление условное: из параллельных запросов с одним вызовом токены
+	// получит только один
+	consumed, err := db.DeleteLoginChallenge(challenge.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Delete login challenge error: %v", err)
+		return
+	}
+	if !consumed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+	completeLogin(c, user, keys)
+}
+
+// enrollTOTP — POST /2fa/enroll. Секрет сохраняется, но 2FA включается
+// только после подтверждения кодом, чтобы пользователь не заблокировал
+// себя неправильно настроенным приложением.
+func enrollTOTP(c *gin.Context) {
+	user := currentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Generate TOTP secret error: %v", err)
+		return
+	}
+	if err := db.SetTOTPSecret(user.ID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Save TOTP secret error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": otpauthURI(user.Username, secret)})
+}
+
+type codeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// confirmTOTP — POST /2fa/confirm. Включает 2FA и единственный раз
+// показывает коды восстановления.
+func confirmTOTP(c *gin.Context) {
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Enrollment has not been started"})
+		return
+	}
+	keys := codeAttemptKey(user)
+	if codeAttemptsBlocked(c, user, keys) {
+		return
+	}
+	step, ok := verifyTOTP(user.TOTPSecret, normalizeCode(req.Code), now(), 0)
+	if !ok {
+		recordLoginFailure(keys, user.Username, c.ClientIP(), failureInvalidCode)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+	if err := attempts.Reset(keys[0].key); err != nil {
+		log.Printf("Reset code attempts error: %v", err)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Generate recovery codes error: %v", err)
+		return
+	}
+	if err := db.EnableTOTP(user.ID, step, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Enable TOTP error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// regenerateRecoveryCodes — POST /2fa/recovery-codes. Старые коды
+// перестают действовать.
+func regenerateRecoveryCodes(c *gin.Context) {
+	user, ok := verifiedTOTPUser(c)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Generate recovery codes error: %v", err)
+		return
+	}
+	if err := db.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Replace recovery codes error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// disableTOTP — POST /2fa/disable. Требует действующий код, чтобы
+// украденный access-токен не позволял снять защиту.
+func disableTOTP(c *gin.Context) {
+	user, ok := verifiedTOTPUser(c)
+	if !ok {
+		return
+	}
+
+	if err := db.DisableTOTP(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Disable TOTP error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// verifiedTOTPUser проверяет код из тела запроса у пользователя с
+// включённой 2FA. Неверные коды учитываются в лимите, иначе украденный
+// access-токен позволял бы перебирать коды восстановления. При ошибке
+// отвечает сам.
+func verifiedTOTPUser(c *gin.Context) (*User, bool) {
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	user := currentUser(c)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return nil, false
+	}
+	keys := codeAttemptKey(user)
+	if codeAttemptsBlocked(c, user, keys) {
+		return nil, false
+	}
+	ok, err := checkSecondFactor(user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check second factor error: %v", err)
+		return nil, false
+	}
+	if !ok {
+		recordLoginFailure(keys, user.Username, c.ClientIP(), failureInvalidCode)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return nil, false
+	}
+	if err := attempts.Reset(keys[0].key); err != nil {
+		log.Printf("Reset code attempts error: %v", err)
+	}
+	return user, true
+}
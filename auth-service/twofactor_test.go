@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTwoFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	savedNow, savedAttempts := now, attempts
+	defer func() { now, attempts = savedNow, savedAttempts }()
+	clock := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+
+	r := gin.Default()
+	r.POST("/login", login)
+	r.POST("/login/2fa", loginSecondFactor)
+	r.POST("/2fa/enroll", requireUser(), enrollTOTP)
+	r.POST("/2fa/confirm", requireUser(), confirmTOTP)
+	r.POST("/2fa/disable", requireUser(), disableTOTP)
+
+	secret, _ := newTOTPSecret()
+	key, _ := totpEncoding.DecodeString(secret)
+	currentCode := totpCode(key, totpStep(clock))
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correct-horse-42"), bcrypt.MinCost)
+
+	newUser := func(enabled bool) *User {
+		user := &User{Username: "alice", Password: string(hashedPassword), Role: roleMember}
+		user.ID = 1
+		if enabled {
+			user.TOTPSecret = secret
+			user.TOTPEnabled = true
+		}
+		return user
+	}
+	newDB := func(user *User) *MockDatabase {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		attempts = newMemoryAttemptStore()
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(false, nil).Maybe()
+		mockDB.On("FindUserByID", uint(1)).Return(user, nil).Maybe()
+		mockDB.On("CreateRefreshToken", mock.Anything).Return(nil).Maybe()
+		mockDB.On("CreateFailedLogin", mock.Anything).Return(nil).Maybe()
+		return mockDB
+	}
+	post := func(path, body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+	decode := func(resp *httptest.ResponseRecorder) map[string]interface{} {
+		var body map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		return body
+	}
+
+	t.Run("enroll returns secret and otpauth URI", func(t *testing.T) {
+		mockDB := newDB(newUser(false))
+		token := issueTestToken(t)
+		db = mockDB
+		mockDB.On("SetTOTPSecret", uint(1), mock.AnythingOfType("string")).Return(nil)
+
+		resp := post("/2fa/enroll", "", token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		body := decode(resp)
+		assert.Len(t, body["secret"], 32)
+		assert.Contains(t, body["otpauth_uri"], "otpauth://totp/Booking%20Service:alice?")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("confirm enables 2FA and returns recovery codes", func(t *testing.T) {
+		user := newUser(false)
+		user.TOTPSecret = secret
+		mockDB := newDB(user)
+		token := issueTestToken(t)
+		db = mockDB
+		mockDB.On("EnableTOTP", uint(1), totpStep(clock), mock.MatchedBy(func(hashes []string) bool {
+			return len(hashes) == recoveryCodeCount
+		})).Return(nil)
+
+		resp := post("/2fa/confirm", `{"code": "`+currentCode+`"}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Len(t, decode(resp)["recovery_codes"], recoveryCodeCount)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("confirm rejects wrong code", func(t *testing.T) {
+		user := newUser(false)
+		user.TOTPSecret = secret
+		mockDB := newDB(user)
+		token := issueTestToken(t)
+		db = mockDB
+
+		resp := post("/2fa/confirm", `{"code": "000000"}`, token)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertNotCalled(t, "EnableTOTP", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("login with 2FA returns a challenge", func(t *testing.T) {
+		mockDB := newDB(newUser(true))
+		mockDB.On("FindUserByUsername", "alice").Return(newUser(true), nil)
+		mockDB.On("CreateLoginChallenge", mock.MatchedBy(func(ch *LoginChallenge) bool {
+			return ch.UserID == 1 && ch.ExpiresAt.Equal(clock.Add(challengeTTL))
+		})).Return(nil)
+
+		resp := post("/login", `{"username": "alice", "password": "correct-horse-42"}`, "")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		body := decode(resp)
+		assert.Equal(t, true, body["two_factor_required"])
+		assert.NotEmpty(t, body["challenge_token"])
+		assert.NotContains(t, body, "token")
+		mockDB.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+
+	challenge := func(attempts int, expiresAt time.Time) *LoginChallenge {
+		ch := &LoginChallenge{UserID: 1, ExpiresAt: expiresAt, Attempts: attempts}
+		ch.ID = 9
+		return ch
+	}
+
+	t.Run("valid code completes login", func(t *testing.T) {
+		mockDB := newDB(newUser(true))
+		mockDB.On("FindLoginChallenge", hashToken("ch")).Return(challenge(0, clock.Add(time.Minute)), nil)
+		mockDB.On("MarkTOTPStepUsed", uint(1), totpStep(clock)).Return(true, nil)
+		mockDB.On("DeleteLoginChallenge", uint(9)).Return(true, nil)
+
+		resp := post("/login/2fa", `{"challenge_token": "ch", "code": "`+currentCode+`"}`, "")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, decode(resp), "token")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("replayed code is rejected", func(t *testing.T) {
+		mockDB := newDB(newUser(true))
+		mockDB.On("FindLoginChallenge", mock.Anything).Return(challenge(0, clock.Add(time.Minute)), nil)
+		mockDB.On("MarkTOTPStepUsed", uint(1), totpStep(clock)).Return(false, nil)
+		mockDB.On("IncrementChallengeAttempts", uint(9)).Return(nil)
+
+		resp := post("/login/2fa", `{"challenge_token": "ch", "code": "`+currentCode+`"}`, "")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.JSONEq(t, `{"error": "Invalid code"}`, resp.Body.String())
+		mockDB.AssertCalled(t, "CreateFailedLogin", mock.MatchedBy(func(entry *FailedLogin) bool {
+			return entry.Reason == failureInvalidCode
+		}))
+		mockDB.AssertNotCalled(t, "DeleteLoginChallenge", mock.Anything)
+	})
+
+	t.Run("recovery code completes login", func(t *testing.T) {
+		mockDB := newDB(newUser(true))
+		mockDB.On("FindLoginChallenge", mock.Anything).Return(challenge(0, clock.Add(time.Minute)), nil)
+		mockDB.On("UseRecoveryCode", uint(1), hashToken("abcde12345"), clock).Return(true, nil)
+		mockDB.On("DeleteLoginChallenge", uint(9)).Return(true, nil)
+
+		resp := post("/login/2fa", `{"challenge_token": "ch", "code": "ABCDE-12345"}`, "")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("exhausted or expired challenge", func(t *testing.T) {
+		for _, ch := range []*LoginChallenge{
+			challenge(maxChallengeAttempts, clock.Add(time.Minute)),
+			challenge(0, clock.Add(-time.Second)),
+		} {
+			mockDB := newDB(newUser(true))
+			mockDB.On("FindLoginChallenge", mock.Anything).Return(ch, nil)
+			mockDB.On("DeleteLoginChallenge", uint(9)).Return(true, nil)
+
+			resp := post("/login/2fa", `{"challenge_token": "ch", "code": "`+currentCode+`"}`, "")
+
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.JSONEq(t, `{"error": "Invalid or expired challenge"}`, resp.Body.String())
+			mockDB.AssertNotCalled(t, "MarkTOTPStepUsed", mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("disable requires a valid code", func(t *testing.T) {
+		mockDB := newDB(newUser(true))
+		token := issueTestToken(t)
+		db = mockDB
+		mockDB.On("MarkTOTPStepUsed", uint(1), totpStep(clock)).Return(true, nil)
+		mockDB.On("DisableTOTP", uint(1)).Return(nil)
+
+		resp := post("/2fa/disable", `{"code": "`+currentCode+`"}`, token)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("wrong codes are rate limited", func(t *testing.T) {
+		mockDB := newDB(newUser(true))
+		token := issueTestToken(t)
+		db = mockDB
+		mockDB.On("UseRecoveryCode", uint(1), mock.Anything, clock).Return(false, nil)
+
+		for i := 0; i < usernameLimit.FreeAttempts; i++ {
+			resp := post("/2fa/disable", `{"code": "aaaaa-bbbbb"}`, token)
+			require.Equal(t, http.StatusBadRequest, resp.Code)
+		}
+		resp := post("/2fa/disable", `{"code": "aaaaa-bbbbb"}`, token)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		// Следующая попытка, даже с верным кодом, получает 429 без проверки
+		resp = post("/2fa/disable", `{"code": "`+currentCode+`"}`, token)
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+		mockDB.AssertNumberOfCalls(t, "UseRecoveryCode", usernameLimit.FreeAttempts+1)
+		mockDB.AssertNotCalled(t, "MarkTOTPStepUsed", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "DisableTOTP", mock.Anything)
+	})
+
+	t.Run("confirm is rate limited", func(t *testing.T) {
+		user := newUser(false)
+		user.TOTPSecret = secret
+		mockDB := newDB(user)
+		token := issueTestToken(t)
+		db = mockDB
+
+		for i := 0; i <= usernameLimit.FreeAttempts; i++ {
+			post("/2fa/confirm", `{"code": "000000"}`, token)
+		}
+		resp := post("/2fa/confirm", `{"code": "`+currentCode+`"}`, token)
+
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		mockDB.AssertNotCalled(t, "EnableTOTP", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("revoked access token cannot enroll", func(t *testing.T) {
+		token := issueTestToken(t)
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("IsTokenRevoked", mock.Anything).Return(true, nil)
+
+		resp := post("/2fa/enroll", "", token)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertNotCalled(t, "SetTOTPSecret", mock.Anything, mock.Anything)
+	})
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, code)
+		assert.Equal(t, hashToken(normalizeCode(code)), hashes[i])
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API-ключи сервисных учётных записей выпускает auth-service. Они
+// начинаются с apiKeyMarker, по нему requireAuth отличает их от JWT.
+const (
+	apiKeyMarker = "bks_"
+	// apiKeyCacheTTL — сколько доверяем результату проверки ключа. Столько
+	// же после отзыва ключ может продолжать работать.
+	apiKeyCacheTTL = 30 * time.Second
+)
+
+// Области действия ключей. Ключ даёт доступ только к маршрутам из
+// routeScopes; все остальные маршруты для него закрыты.
+const (
+	scopeBookingsRead = "bookings:read"
+	scopeRoomsRead    = "rooms:read"
+)
+
+var routeScopes = map[string]string{
+	"GET /bookings":               scopeBookingsRead,
+	"GET /bookings.ics":           scopeBookingsRead,
+	"GET /rooms":                  scopeRoomsRead,
+	"GET /rooms/:id":              scopeRoomsRead,
+	"GET /rooms/:id/availability": scopeRoomsRead,
+	"GET /availability":           scopeRoomsRead,
+}
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// apiKeyVerifier проверяет ключи через auth-service и ненадолго запоминает
+// результат, чтобы табло, опрашивающие сервис, не нагружали auth-service.
+type apiKeyVerifier struct {
+	url    string
+	apiKey string
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	claims *Claims
+	until  time.Time
+}
+
+// apiKeys равен nil, если API_KEY_VERIFY_URL не задан: тогда ключи не принимаются.
+var apiKeys *apiKeyVerifier
+
+func newAPIKeyVerifier(url, apiKey string) *apiKeyVerifier {
+	return &apiKeyVerifier{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 5 * time.Second},
+		cache:  map[string]cachedAPIKey{},
+	}
+}
+
+func loadAPIKeyConfig() {
+	url := os.Getenv("API_KEY_VERIFY_URL")
+	if url == "" {
+		return
+	}
+	if internalAPIKey == "" {
+		log.Fatal("API_KEY_VERIFY_URL requires INTERNAL_API_KEY")
+	}
+	apiKeys = newAPIKeyVerifier(url, internalAPIKey)
+}
+
+// verify возвращает Claims сервисной учётной записи. errInvalidAPIKey
+// означает, что ключ неверен, отозван или истёк; прочие ошибки — что
+// auth-service недоступен.
+func (v *apiKeyVerifier) verify(key string) (*Claims, error) {
+	sum := sha256.Sum256([]byte(key))
+	cacheKey := hex.EncodeToString(sum[:])
+
+	v.mu.Lock()
+	cached, ok := v.cache[cacheKey]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.until) {
+		return cached.claims, nil
+	}
+
+	body, _ := json.Marshal(gin.H{"key": key})
+	req, err := http.NewRequest(http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, errInvalidAPIKey
+	default:
+		return nil, fmt.Errorf("api key verification: unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ServiceAccountID uint      `json:"service_account_id"`
+		Scopes           []string  `json:"scopes"`
+		ExpiresAt        time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.ServiceAccountID == 0 {
+		return nil, errInvalidAPIKey
+	}
+	claims := &Claims{ServiceAccountID: result.ServiceAccountID, Scopes: result.Scopes}
+
+	until := time.Now().Add(apiKeyCacheTTL)
+	if result.ExpiresAt.Before(until) {
+		until = result.ExpiresAt
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Устаревшие записи выбрасываем здесь же, чтобы кэш не рос бесконечно
+	for k, e := range v.cache {
+		if time.Now().After(e.until) {
+			delete(v.cache, k)
+		}
+	}
+	v.cache[cacheKey] = cachedAPIKey{claims: claims, until: until}
+	return claims, nil
+}
+
+// isService сообщает, что запрос сделан по API-ключу, а не пользователем.
+func (c *Claims) isService() bool {
+	return c.ServiceAccountID != 0
+}
+
+func (c *Claims) hasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey обслуживает ветку requireAuth для API-ключей.
+func authenticateAPIKey(c *gin.Context, key string) {
+	if apiKeys == nil {
+		unauthorized(c, "API keys are not accepted")
+		return
+	}
+	claims, err := apiKeys.verify(key)
+	if err != nil {
+		if errors.Is(err, errInvalidAPIKey) {
+			unauthorized(c, "API key is invalid")
+		} else {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication is temporarily unavailable"})
+			log.Printf("Verify API key error: %v", err)
+		}
+		return
+	}
+
+	scope := routeScopes[c.Request.Method+" "+c.FullPath()]
+	if scope == "" || !claims.hasScope(scope) {
+		insufficientScope(c, scope)
+		return
+	}
+	c.Set(claimsKey, claims)
+	c.Next()
+}
+
+// insufficientScope отвечает 403 по RFC 6750. Пустой scope означает, что
+// маршрут ключам недоступен вовсе.
+func insufficientScope(c *gin.Context, scope string) {
+	challenge := `Bearer realm="booking-service", error="insufficient_scope"`
+	if scope != "" {
+		challenge += fmt.Sprintf(`, scope=%q`, scope)
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+}
+
+// isAPIKey отличает API-ключ от JWT.
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyMarker)
+}
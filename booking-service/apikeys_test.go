@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Заглушка POST /internal/api-keys/verify из auth-service
+	calls := 0
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "Bearer internal", r.Header.Get("Authorization"))
+		var req struct {
+			Key string `json:"key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.Key {
+		case "bks_display1_secret":
+			json.NewEncoder(w).Encode(gin.H{
+				"service_account_id": 3,
+				"scopes":             []string{scopeBookingsRead},
+				"expires_at":         time.Now().Add(time.Hour),
+			})
+		case "bks_broken00_secret":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(gin.H{"error": "Invalid API key"})
+		}
+	}))
+	defer authService.Close()
+
+	saved := apiKeys
+	defer func() { apiKeys = saved }()
+	apiKeys = newAPIKeyVerifier(authService.URL, "internal")
+
+	r := gin.Default()
+	api := r.Group("/", requireAuth())
+	api.GET("/bookings", getBookings)
+	api.POST("/book", createBooking)
+	api.GET("/rooms", getRooms)
+	api.POST("/calendar", createCalendarFeed)
+
+	send := func(method, path, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("key with bookings:read sees all bookings and is cached", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("Order", mock.Anything).Return(mockDB)
+		mockDB.On("Limit", mock.Anything).Return(mockDB)
+		mockDB.On("Find", mock.Anything, mock.Anything).Return(nil)
+		calls = 0
+
+		first := send(http.MethodGet, "/bookings", "bks_display1_secret")
+		second := send(http.MethodGet, "/bookings", "bks_display1_secret")
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, 1, calls)
+		mockDB.AssertNotCalled(t, "Where", mock.Anything, mock.Anything)
+	})
+
+	t.Run("mine is rejected for a key", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := send(http.MethodGet, "/bookings?mine=true", "bks_display1_secret")
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "Parameter mine requires a user token"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+	})
+
+	t.Run("routes outside the key's scopes are forbidden", func(t *testing.T) {
+		db = new(MockDatabase)
+
+		for _, tc := range []struct{ method, path, scope string }{
+			{http.MethodGet, "/rooms", `scope="rooms:read"`},
+			{http.MethodPost, "/book", ""},
+			{http.MethodPost, "/calendar", ""},
+		} {
+			resp := send(tc.method, tc.path, "bks_display1_secret")
+
+			assert.Equal(t, http.StatusForbidden, resp.Code, tc.path)
+			challenge := resp.Header().Get("WWW-Authenticate")
+			assert.Contains(t, challenge, `error="insufficient_scope"`, tc.path)
+			assert.Contains(t, challenge, tc.scope, tc.path)
+		}
+	})
+
+	t.Run("unknown key is rejected", func(t *testing.T) {
+		resp := send(http.MethodGet, "/bookings", "bks_unknown0_secret")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("auth-service failure is not reported as a bad key", func(t *testing.T) {
+		resp := send(http.MethodGet, "/bookings", "bks_broken00_secret")
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	})
+
+	t.Run("keys are rejected when verification is not configured", func(t *testing.T) {
+		apiKeys = nil
+		defer func() { apiKeys = newAPIKeyVerifier(authService.URL, "internal") }()
+
+		resp := send(http.MethodGet, "/bookings", "bks_display1_secret")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
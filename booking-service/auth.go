@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"booking/shared/jwks"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+const claimsKey = "claims"
+
+// jwtKeys — открытые ключи auth-service. Секрета, которым можно выпускать
+// токены, у booking-service нет.
+var jwtKeys *jwks.Cache
+
+func loadJWKS() (*jwks.Cache, error) {
+	url := os.Getenv("JWKS_URL")
+	if url == "" {
+		return nil, fmt.Errorf("JWKS_URL is not set")
+	}
+	return jwks.New(url), nil
+}
+
+// requireAuth проверяет bearer-токен (JWT пользователя или API-ключ) и
+// кладёт его Claims в контекст.
+// Ответ 401 всегда одинаковый, а причина передаётся в WWW-Authenticate
+// по RFC 6750.
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, tokenStr, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		tokenStr = strings.TrimSpace(tokenStr)
+		if !strings.EqualFold(scheme, "Bearer") || tokenStr == "" {
+			unauthorized(c, "")
+			return
+		}
+
+		if isAPIKey(tokenStr) {
+			authenticateAPIKey(c, tokenStr)
+			return
+		}
+
+		token, err := validateToken(tokenStr)
+		if err != nil || !token.Valid {
+			unauthorized(c, tokenErrorDescription(err))
+			return
+		}
+
+		c.Set(claimsKey, token.Claims.(*Claims))
+		c.Next()
+	}
+}
+
+// requireInternalKey пропускает служебные запросы других сервисов с общим
+// секретом INTERNAL_API_KEY. Без секрета такие маршруты отключены.
+func requireInternalKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := "Bearer " + internalAPIKey
+		if internalAPIKey == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentClaims возвращает Claims, положенные requireAuth.
+func currentClaims(c *gin.Context) *Claims {
+	return c.MustGet(claimsKey).(*Claims)
+}
+
+func tokenErrorDescription(err error) string {
+	var ve *jwt.ValidationError
+	switch {
+	case errors.Is(err, errTokenRevoked):
+		return "token has been revoked"
+	case errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorExpired != 0:
+		return "token has expired"
+	default:
+		return "token is invalid"
+	}
+}
+
+// unauthorized отвечает 401. Пустое описание означает, что токена не было:
+// тогда по RFC 6750 код ошибки в заголовке не указывается.
+func unauthorized(c *gin.Context, description string) {
+	challenge := `Bearer realm="booking-service"`
+	if description != "" {
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, description)
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"booking/shared/revocation"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	saved := revoked
+	defer func() { revoked = saved }()
+	revoked = revocation.New("", "")
+	revoked.Add("revoked-jti", time.Now().Add(time.Hour))
+
+	r := gin.Default()
+	r.GET("/whoami", requireAuth(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": currentClaims(c).UserID})
+	})
+
+	expired := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+		UserID:         1,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+	})
+	expired.Header["kid"] = "test"
+	expiredToken, _ := expired.SignedString(testKey)
+
+	tests := []struct {
+		name      string
+		header    string
+		challenge string
+	}{
+		{"missing header", "", `Bearer realm="booking-service"`},
+		{"wrong scheme", "Token abc", `Bearer realm="booking-service"`},
+		{"empty token", "Bearer ", `Bearer realm="booking-service"`},
+		{"garbage token", "Bearer abc", `Bearer realm="booking-service", error="invalid_token", error_description="token is invalid"`},
+		{"expired token", "Bearer " + expiredToken, `Bearer realm="booking-service", error="invalid_token", error_description="token has expired"`},
+		{"revoked token", "Bearer " + tokenWithJTI("revoked-jti"), `Bearer realm="booking-service", error="invalid_token", error_description="token has been revoked"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/whoami", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp := httptest.NewRecorder()
+
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.JSONEq(t, `{"error": "Unauthorized"}`, resp.Body.String())
+			assert.Equal(t, tt.challenge, resp.Header().Get("WWW-Authenticate"))
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/whoami", nil)
+		req.Header.Set("Authorization", "bearer "+signedToken(7))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"user_id": 7}`, resp.Body.String())
+		assert.Empty(t, resp.Header().Get("WWW-Authenticate"))
+	})
+}
+
+func TestValidateTokenRejectsHMAC(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: 1})
+	token.Header["kid"] = "test"
+	tokenString, _ := token.SignedString([]byte("secret"))
+
+	_, err := validateToken(tokenString)
+	assert.Error(t, err)
+}
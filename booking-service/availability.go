@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timeSlot — свободный промежуток времени в комнате.
+type timeSlot struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+// freeSlots возвращает промежутки внутри [from, to), не занятые бронированиями.
+func freeSlots(from, to time.Time, bookings []Booking) []timeSlot {
+	sorted := make([]Booking, len(bookings))
+	copy(sorted, bookings)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+
+	slots := []timeSlot{}
+	cursor := from
+	for _, b := range sorted {
+		if b.StartTime.After(cursor) {
+			end := b.StartTime
+			if end.After(to) {
+				end = to
+			}
+			if end.After(cursor) {
+				slots = append(slots, timeSlot{StartTime: cursor, EndTime: end})
+			}
+		}
+		if b.EndTime.After(cursor) {
+			cursor = b.EndTime
+		}
+	}
+	if to.After(cursor) {
+		slots = append(slots, timeSlot{StartTime: cursor, EndTime: to})
+	}
+	return slots
+}
+
+// parseWindow читает параметры start и end в формате RFC 3339.
+func parseWindow(c *gin.Context) (time.Time, time.Time, bool) {
+	start, err := time.Parse(time.RFC3339, c.Query("start"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start time"})
+		return time.Time{}, time.Time{}, false
+	}
+	end, err := time.Parse(time.RFC3339, c.Query("end"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end time"})
+		return time.Time{}, time.Time{}, false
+	}
+	if !end.After(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End time must be after start time"})
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// getAvailability возвращает активные комнаты без пересекающихся бронирований
+// в окне [start, end). Пересечение считается так же, как в createBooking.
+func getAvailability(c *gin.Context) {
+	start, end, ok := parseWindow(c)
+	if !ok {
+		return
+	}
+
+	capacity := 0
+	if v := c.Query("capacity"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid capacity"})
+			return
+		}
+		capacity = n
+	}
+
+	var rooms []Room
+	if err := db.Where("active = ? AND capacity >= ?", true, capacity).Find(&rooms); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve rooms"})
+		return
+	}
+
+	var bookings []Booking
+	if err := db.Where("start_time < ? AND end_time > ?", end, start).Find(&bookings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve bookings"})
+		return
+	}
+
+	busy := make(map[uint]bool, len(bookings))
+	for _, b := range bookings {
+		busy[b.RoomID] = true
+	}
+
+	free := []Room{}
+	for _, room := range rooms {
+		if !busy[room.ID] {
+			free = append(free, room)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"rooms": free})
+}
+
+// getRoomAvailability возвращает свободные промежутки комнаты за сутки (UTC)
+// из параметра date в формате YYYY-MM-DD.
+func getRoomAvailability(c *gin.Context) {
+	room, ok := roomFromParam(c)
+	if !ok {
+		return
+	}
+
+	day, err := time.Parse(time.DateOnly, c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date"})
+		return
+	}
+	dayEnd := day.AddDate(0, 0, 1)
+
+	var bookings []Booking
+	if err := db.Where("room_id = ? AND start_time < ? AND end_time > ?", room.ID, dayEnd, day).Find(&bookings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve bookings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"room": room, "free_slots": freeSlots(day, dayEnd, bookings)})
+}
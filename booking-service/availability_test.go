@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFreeSlots(t *testing.T) {
+	day := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return day.Add(time.Duration(h) * time.Hour) }
+
+	bookings := []Booking{
+		{StartTime: at(13), EndTime: at(14)},
+		{StartTime: at(9), EndTime: at(11)},
+		{StartTime: at(10), EndTime: at(12)},
+		{StartTime: at(23), EndTime: at(25)},
+	}
+
+	slots := freeSlots(day, at(24), bookings)
+
+	assert.Equal(t, []timeSlot{
+		{StartTime: at(0), EndTime: at(9)},
+		{StartTime: at(12), EndTime: at(13)},
+		{StartTime: at(14), EndTime: at(23)},
+	}, slots)
+	assert.Equal(t, []timeSlot{{StartTime: at(0), EndTime: at(24)}}, freeSlots(day, at(24), nil))
+}
+
+func TestGetAvailability(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/availability", requireAuth(), getAvailability)
+
+	t.Run("returns rooms without overlapping bookings", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "active = ? AND capacity >= ?", []interface{}{true, 6}).Return(mockDB)
+		mockDB.On("Where", "start_time < ? AND end_time > ?", mock.Anything).Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]main.Room"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			rooms := args.Get(0).(*[]Room)
+			*rooms = []Room{{Name: "A", Capacity: 6, Active: true}, {Name: "B", Capacity: 10, Active: true}}
+			(*rooms)[0].ID = 1
+			(*rooms)[1].ID = 2
+		})
+		mockDB.On("Find", mock.AnythingOfType("*[]main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			bookings := args.Get(0).(*[]Booking)
+			*bookings = []Booking{{RoomID: 1}}
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, "/availability?start=2030-01-01T10:00:00Z&end=2030-01-01T11:00:00Z&capacity=6", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response struct {
+			Rooms []Room `json:"rooms"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		assert.Len(t, response.Rooms, 1)
+		assert.Equal(t, "B", response.Rooms[0].Name)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("inverted window", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/availability?start=2030-01-01T11:00:00Z&end=2030-01-01T10:00:00Z", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "End time must be after start time"}`, resp.Body.String())
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// bookingFilter — разобранные параметры запроса GET /bookings.
+type bookingFilter struct {
+	RoomID uint
+	UserID uint
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Desc   bool
+	Cursor *bookingCursor
+}
+
+// bookingCursor указывает на последнюю отданную запись. Сортировка идёт по
+// (start_time, id), поэтому курсор хранит обе величины.
+type bookingCursor struct {
+	StartTime time.Time
+	ID        uint
+}
+
+func (cur bookingCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d", cur.StartTime.UnixNano(), cur.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*bookingCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	i, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &bookingCursor{StartTime: time.Unix(0, n).UTC(), ID: uint(i)}, nil
+}
+
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	return uint(id), err
+}
+
+// parseBookingFilter разбирает query-параметры. При ошибке отвечает 400 сам.
+// mine=true подставляет UserID из токена; у запроса по API-ключу
+// пользователя нет, и mine для него — ошибка, а не пустой фильтр.
+func parseBookingFilter(c *gin.Context) (*bookingFilter, bool) {
+	f := &bookingFilter{Limit: defaultPageSize}
+	fail := func(msg string) (*bookingFilter, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return nil, false
+	}
+
+	var err error
+	if v := c.Query("room"); v != "" {
+		if f.RoomID, err = parseID(v); err != nil {
+			return fail("Invalid room")
+		}
+	}
+	if v := c.Query("user"); v != "" {
+		if f.UserID, err = parseID(v); err != nil {
+			return fail("Invalid user")
+		}
+	}
+	if c.Query("mine") == "true" {
+		claims := currentClaims(c)
+		if claims.isService() {
+			return fail("Parameter mine requires a user token")
+		}
+		f.UserID = claims.UserID
+	}
+	if v := c.Query("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return fail("Invalid from time")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return fail("Invalid to time")
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fail("Invalid limit")
+		}
+		f.Limit = min(n, maxPageSize)
+	}
+	switch c.DefaultQuery("sort", "asc") {
+	case "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return fail("Invalid sort order")
+	}
+	if v := c.Query("cursor"); v != "" {
+		if f.Cursor, err = decodeCursor(v); err != nil {
+			return fail("Invalid cursor")
+		}
+	}
+	return f, true
+}
+
+// apply добавляет условия фильтра к запросу. Диапазон from/to выбирает
+// бронирования, пересекающиеся с ним.
+func (f *bookingFilter) apply(q Database) Database {
+	if f.RoomID != 0 {
+		q = q.Where("room_id = ?", f.RoomID)
+	}
+	if f.UserID != 0 {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("end_time > ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("start_time < ?", f.To)
+	}
+
+	order := "start_time ASC, id ASC"
+	if f.Desc {
+		order = "start_time DESC, id DESC"
+	}
+	if f.Cursor != nil {
+		op := ">"
+		if f.Desc {
+			op = "<"
+		}
+		q = q.Where("start_time "+op+" ? OR (start_time = ? AND id "+op+" ?)",
+			f.Cursor.StartTime, f.Cursor.StartTime, f.Cursor.ID)
+	}
+	// Берём на одну запись больше, чтобы понять, есть ли следующая страница
+	return q.Order(order).Limit(f.Limit + 1)
+}
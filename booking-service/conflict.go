@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxSuggestions = 3
+	// suggestionWindow — насколько далеко от запрошенного времени ищем
+	// свободные слоты в обе стороны.
+	suggestionWindow = 24 * time.Hour
+)
+
+// conflictError — запрошенный интервал пересекается с существующей бронью.
+// Existing может быть пустым, если конфликт поймало ограничение в БД, а не
+// проверка checkOverlap.
+type conflictError struct {
+	Requested Booking
+	Existing  Booking
+}
+
+func (e *conflictError) Error() string {
+	return errRoomBooked.Error()
+}
+
+// Is позволяет по-прежнему проверять конфликт через errors.Is(err, errRoomBooked).
+func (e *conflictError) Is(target error) bool {
+	return target == errRoomBooked
+}
+
+// conflictInfo — то, что клиент видит о чужой брони. Владелец раскрывается
+// только тому, кому он и так доступен.
+type conflictInfo struct {
+	BookingID uint      `json:"booking_id"`
+	RoomID    uint      `json:"room_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	UserID    *uint     `json:"user_id,omitempty"`
+}
+
+// canSeeOwner решает, раскрывать ли владельца конфликтующей брони: его
+// видят сам владелец, администратор и управляющий комнатой.
+func canSeeOwner(claims *Claims, booking *Booking) bool {
+	allowed, err := canManage(claims, booking.UserID, booking.RoomID)
+	if err != nil {
+		log.Printf("Check room manager error: %v", err)
+	}
+	return allowed
+}
+
+// findConflict ищет пересекающуюся бронь вне транзакции, когда о конфликте
+// сообщила база и подробностей нет.
+func findConflict(requested *Booking) (*Booking, error) {
+	var existing Booking
+	err := db.Where("room_id = ? AND start_time < ? AND end_time > ? AND id <> ?",
+		requested.RoomID, requested.EndTime, requested.StartTime, requested.ID).First(&existing)
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// suggestSlots предлагает ближайшие к запрошенному свободные интервалы той же
+// длительности в той же комнате, выровненные по rules.Granularity.
+func suggestSlots(requested *Booking) ([]timeSlot, error) {
+	duration := requested.EndTime.Sub(requested.StartTime)
+	from := requested.StartTime.Add(-suggestionWindow)
+	to := requested.EndTime.Add(suggestionWindow)
+	if earliest := now(); from.Before(earliest) {
+		from = earliest
+	}
+	if !to.After(from) {
+		return []timeSlot{}, nil
+	}
+
+	var bookings []Booking
+	if err := db.Where("room_id = ? AND start_time < ? AND end_time > ? AND id <> ?",
+		requested.RoomID, to, from, requested.ID).Find(&bookings); err != nil {
+		return nil, err
+	}
+
+	var candidates []timeSlot
+	for _, gap := range freeSlots(from, to, bookings) {
+		// Два кандидата на промежуток: как можно раньше и как можно позже
+		for _, start := range []time.Time{alignUp(gap.StartTime), alignDown(gap.EndTime.Add(-duration))} {
+			end := start.Add(duration)
+			if start.Before(gap.StartTime) || end.After(gap.EndTime) {
+				continue
+			}
+			candidates = append(candidates, timeSlot{StartTime: start, EndTime: end})
+		}
+	}
+
+	distance := func(s timeSlot) time.Duration {
+		d := s.StartTime.Sub(requested.StartTime)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return distance(candidates[i]) < distance(candidates[j]) })
+
+	suggestions := []timeSlot{}
+	for _, s := range candidates {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		if len(suggestions) > 0 && suggestions[len(suggestions)-1].StartTime.Equal(s.StartTime) {
+			continue
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, nil
+}
+
+func alignUp(t time.Time) time.Time {
+	if rules.Granularity <= 0 {
+		return t
+	}
+	aligned := t.Truncate(rules.Granularity)
+	if aligned.Before(t) {
+		aligned = aligned.Add(rules.Granularity)
+	}
+	return aligned
+}
+
+func alignDown(t time.Time) time.Time {
+	if rules.Granularity <= 0 {
+		return t
+	}
+	return t.Truncate(rules.Granularity)
+}
+
+// respondConflict отвечает 409 с данными о мешающей брони и вариантами
+// свободного времени. Ошибки при сборе подробностей не мешают ответу.
+func respondConflict(c *gin.Context, claims *Claims, err error, requested *Booking) {
+	var ce *conflictError
+	if !errors.As(err, &ce) {
+		ce = &conflictError{Requested: *requested}
+	}
+
+	body := gin.H{
+		"error":     err.Error(),
+		"requested": timeSlot{StartTime: ce.Requested.StartTime, EndTime: ce.Requested.EndTime},
+	}
+
+	existing := &ce.Existing
+	if existing.ID == 0 {
+		found, findErr := findConflict(&ce.Requested)
+		if findErr != nil {
+			log.Printf("Find conflict error: %v", findErr)
+		}
+		existing = found
+	}
+	if existing != nil {
+		info := conflictInfo{
+			BookingID: existing.ID,
+			RoomID:    existing.RoomID,
+			StartTime: existing.StartTime,
+			EndTime:   existing.EndTime,
+		}
+		if canSeeOwner(claims, existing) {
+			info.UserID = &existing.UserID
+		}
+		body["conflict"] = info
+	}
+
+	suggestions, suggestErr := suggestSlots(&ce.Requested)
+	if suggestErr != nil {
+		log.Printf("Suggest slots error: %v", suggestErr)
+	} else {
+		body["suggestions"] = suggestions
+	}
+
+	c.JSON(http.StatusConflict, body)
+}
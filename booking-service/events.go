@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// События жизненного цикла бронирования.
+const (
+	eventBookingCreated   = "BookingCreated"
+	eventBookingUpdated   = "BookingUpdated"
+	eventBookingCancelled = "BookingCancelled"
+)
+
+const (
+	relayInterval = 5 * time.Second
+	relayBatch    = 100
+	// relayBaseDelay удваивается с каждой неудачной попыткой, но не больше
+	// relayMaxDelay: событие отправляется, пока его не примут.
+	relayBaseDelay = 5 * time.Second
+	relayMaxDelay  = 10 * time.Minute
+	// relayLease — на сколько реплика забирает пачку событий себе. Если
+	// реплика не успела их отправить (упала или проход затянулся), события
+	// снова станут доступны остальным.
+	relayLease = 2 * time.Minute
+	// outboxRetention — сколько хранятся уже доставленные события.
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// OutboxEvent — событие, ожидающее отправки в notification-service.
+// Записывается в той же транзакции, что и изменение брони, поэтому событие
+// есть тогда и только тогда, когда изменение сохранено.
+type OutboxEvent struct {
+	ID      uint   `gorm:"primaryKey"`
+	EventID string `gorm:"uniqueIndex"`
+	Type    string
+	// BookingID нужен, чтобы события одной брони уходили строго по порядку
+	BookingID uint `gorm:"index"`
+	// Payload — тело запроса к notification-service (bookingEvent в JSON)
+	Payload       string `gorm:"type:jsonb"`
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string
+	DeliveredAt   *time.Time `gorm:"index"`
+}
+
+// bookingEvent — то, что получает notification-service. По ID получатель
+// отсеивает повторы: relay доставляет событие как минимум один раз.
+type bookingEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	BookingID  uint      `json:"booking_id"`
+	UserID     uint      `json:"user_id"`
+	ActorID    uint      `json:"actor_id"`
+	RoomID     uint      `json:"room_id"`
+	RoomName   string    `json:"room_name"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// recordEvent добавляет событие о брони в outbox внутри транзакции tx.
+// actorID — кто совершил действие: бронь может отменить не владелец.
+func recordEvent(tx *gorm.DB, eventType string, booking *Booking, actorID uint) error {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	at := now()
+	event := bookingEvent{
+		ID:         hex.EncodeToString(raw),
+		Type:       eventType,
+		BookingID:  booking.ID,
+		UserID:     booking.UserID,
+		ActorID:    actorID,
+		RoomID:     booking.RoomID,
+		RoomName:   booking.RoomName,
+		StartTime:  booking.StartTime,
+		EndTime:    booking.EndTime,
+		OccurredAt: at,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboxEvent{
+		EventID:       event.ID,
+		Type:          eventType,
+		BookingID:     booking.ID,
+		Payload:       string(payload),
+		NextAttemptAt: at,
+	}).Error
+}
+
+// cancelBookings отменяет брони, подходящие под условие, и записывает
+// событие об отмене каждой. Брони блокируются до удаления, поэтому
+// параллельная отмена одной из них не даст второго события.
+func cancelBookings(tx *gorm.DB, actorID uint, query string, args ...interface{}) (int, error) {
+	var bookings []Booking
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).Find(&bookings).Error; err != nil {
+		return 0, err
+	}
+	if len(bookings) == 0 {
+		return 0, nil
+	}
+	if err := tx.Delete(&bookings).Error; err != nil {
+		return 0, err
+	}
+	for i := range bookings {
+		if err := recordEvent(tx, eventBookingCancelled, &bookings[i], actorID); err != nil {
+			return 0, err
+		}
+	}
+	return len(bookings), nil
+}
+
+// outboxRelay отправляет события из outbox в notification-service.
+type outboxRelay struct {
+	url    string
+	apiKey string
+	client *http.Client
+	wake   chan struct{}
+}
+
+var relay *outboxRelay
+
+func newOutboxRelay(url, apiKey string) *outboxRelay {
+	return &outboxRelay{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func loadOutboxConfig() {
+	url := strings.TrimRight(os.Getenv("NOTIFICATION_URL"), "/")
+	if url == "" {
+		log.Printf("NOTIFICATION_URL is not set, booking events stay in the outbox")
+		return
+	}
+	if internalAPIKey == "" {
+		log.Fatalf("NOTIFICATION_URL requires INTERNAL_API_KEY")
+	}
+	relay = newOutboxRelay(url+"/events", internalAPIKey)
+	go relay.run(relayInterval)
+}
+
+// wakeRelay запускает отправку сразу после фиксации транзакции, не
+// дожидаясь очередного прохода.
+func wakeRelay() {
+	if relay == nil {
+		return
+	}
+	select {
+	case relay.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *outboxRelay) run(interval time.Duration) {
+	for {
+		if err := r.relayPending(); err != nil {
+			log.Printf("Relay booking events error: %v", err)
+		}
+		if err := db.Where("delivered_at < ?", now().Add(-outboxRetention)).Delete(&OutboxEvent{}); err != nil {
+			log.Printf("Prune outbox error: %v", err)
+		}
+		select {
+		case <-r.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// claimableEvents — ожидающие события, перед которыми нет неотправленных
+// событий той же брони. Пока первое событие брони не доставлено (ждёт
+// повтора или забрано другой репликой), следующие не отправляются, поэтому
+// события одной брони приходят по порядку. События разных броней порядка
+// между собой не сохраняют.
+const claimableEvents = `delivered_at IS NULL AND next_attempt_at <= ?
+  AND NOT EXISTS (SELECT 1 FROM outbox_events earlier
+                  WHERE earlier.booking_id = outbox_events.booking_id
+                    AND earlier.id < outbox_events.id AND earlier.delivered_at IS NULL)`
+
+func (g *GormDatabase) ClaimOutboxEvents(at, leaseUntil time.Time, limit int) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := g.Conn.Transaction(func(tx *gorm.DB) error {
+		// SKIP LOCKED: строки, которые сейчас забирает другая реплика,
+		// пропускаются, а не ждут её транзакции
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(claimableEvents, at).Order("id").Limit(limit).Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		ids := make([]uint, len(events))
+		for i := range events {
+			ids[i] = events[i].ID
+			events[i].NextAttemptAt = leaseUntil
+		}
+		return tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("next_attempt_at", leaseUntil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// relayPending забирает пачку событий и отправляет их по порядку. На первой
+// неудаче проход прекращается: скорее всего notification-service недоступен.
+// Неотправленные события пачки вернутся после relayLease.
+func (r *outboxRelay) relayPending() error {
+	events, err := db.ClaimOutboxEvents(now(), now().Add(relayLease), relayBatch)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		event := &events[i]
+		sendErr := r.send(event)
+		if sendErr != nil {
+			event.Attempts++
+			event.LastError = sendErr.Error()
+			event.NextAttemptAt = now().Add(relayDelay(event.Attempts))
+		} else {
+			delivered := now()
+			event.DeliveredAt = &delivered
+			event.LastError = ""
+		}
+		if err := db.Save(event); err != nil {
+			return err
+		}
+		if sendErr != nil {
+			return fmt.Errorf("event %s (attempt %d): %w", event.EventID, event.Attempts, sendErr)
+		}
+	}
+	return nil
+}
+
+func (r *outboxRelay) send(event *OutboxEvent) error {
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader([]byte(event.Payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification-service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// relayDelay — пауза перед следующей попыткой после attempts неудач.
+func relayDelay(attempts int) time.Duration {
+	delay := relayBaseDelay
+	for i := 1; i < attempts && delay < relayMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > relayMaxDelay {
+		delay = relayMaxDelay
+	}
+	return delay
+}
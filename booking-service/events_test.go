@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	fixed := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	pending := []OutboxEvent{
+		{ID: 1, EventID: "e1", Type: eventBookingCreated, BookingID: 7, Payload: `{"id":"e1","type":"BookingCreated","booking_id":7}`},
+		{ID: 2, EventID: "e2", Type: eventBookingCancelled, BookingID: 7, Payload: `{"id":"e2","type":"BookingCancelled","booking_id":7}`, Attempts: 2},
+	}
+	// withPending возвращает мок с ожидающими событиями; сохранённые
+	// события попадают в saved
+	withPending := func(events ...OutboxEvent) (*MockDatabase, *[]OutboxEvent) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		var saved []OutboxEvent
+		mockDB.On("ClaimOutboxEvents", fixed, fixed.Add(relayLease), relayBatch).Return(append([]OutboxEvent(nil), events...), nil)
+		mockDB.On("Save", mock.AnythingOfType("*main.OutboxEvent")).Return(nil).Run(func(args mock.Arguments) {
+			saved = append(saved, *args.Get(0).(*OutboxEvent))
+		})
+		return mockDB, &saved
+	}
+
+	t.Run("delivers events in order and marks them delivered", func(t *testing.T) {
+		var received []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer internal", r.Header.Get("Authorization"))
+			body, _ := io.ReadAll(r.Body)
+			received = append(received, string(body))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+		_, saved := withPending(pending...)
+
+		require.NoError(t, newOutboxRelay(server.URL, "internal").relayPending())
+
+		assert.Equal(t, []string{pending[0].Payload, pending[1].Payload}, received)
+		require.Len(t, *saved, 2)
+		for _, event := range *saved {
+			require.NotNil(t, event.DeliveredAt)
+			assert.Equal(t, fixed, *event.DeliveredAt)
+		}
+	})
+
+	t.Run("failure schedules a retry and stops the pass", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+		_, saved := withPending(pending[0], pending[1], OutboxEvent{ID: 3, EventID: "e3", Payload: `{}`})
+
+		err := newOutboxRelay(server.URL, "internal").relayPending()
+
+		assert.ErrorContains(t, err, "notification-service returned 503")
+		assert.Equal(t, 2, calls)
+		require.Len(t, *saved, 2)
+		failed := (*saved)[1]
+		assert.Nil(t, failed.DeliveredAt)
+		assert.Equal(t, 3, failed.Attempts)
+		assert.Equal(t, fixed.Add(4*relayBaseDelay), failed.NextAttemptAt)
+		assert.Contains(t, failed.LastError, "503")
+	})
+}
+
+// TestClaimOutboxEvents проверяет на настоящем Postgres, что две реплики
+// не забирают одно событие и что следующее событие брони ждёт доставки
+// предыдущего. Запускается, только если задан TEST_DATABASE_URL.
+func TestClaimOutboxEvents(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	store := &GormDatabase{Conn: database}
+
+	// Уже накопившиеся события других тестов забираются заранее, чтобы не
+	// мешать подсчёту
+	at := time.Now().UTC().Add(time.Hour)
+	_, err = store.ClaimOutboxEvents(at, at.Add(24*time.Hour), 1<<20)
+	require.NoError(t, err)
+	bookingID := uint(time.Now().UnixNano()%1000000) + 2000000
+	tag := fmt.Sprint(time.Now().UnixNano())
+	t.Cleanup(func() {
+		database.Where("event_id LIKE ?", tag+"%").Delete(&OutboxEvent{})
+	})
+	for i, id := range []uint{bookingID, bookingID, bookingID + 1} {
+		require.NoError(t, database.Create(&OutboxEvent{
+			EventID:       fmt.Sprintf("%s-%d", tag, i),
+			BookingID:     id,
+			Payload:       "{}",
+			NextAttemptAt: at,
+		}).Error)
+	}
+
+	first, err := store.ClaimOutboxEvents(at, at.Add(relayLease), relayBatch)
+	require.NoError(t, err)
+	// Второе событие брони не забирается, пока первое не доставлено
+	require.Len(t, first, 2)
+	assert.Equal(t, []uint{bookingID, bookingID + 1}, []uint{first[0].BookingID, first[1].BookingID})
+
+	second, err := store.ClaimOutboxEvents(at, at.Add(relayLease), relayBatch)
+	require.NoError(t, err)
+	assert.Empty(t, second, "events claimed by another relay")
+
+	delivered := at
+	first[0].DeliveredAt = &delivered
+	require.NoError(t, store.Save(&first[0]))
+	next, err := store.ClaimOutboxEvents(at, at.Add(relayLease), relayBatch)
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	assert.Equal(t, tag+"-1", next[0].EventID)
+}
+
+func TestRelayDelay(t *testing.T) {
+	assert.Equal(t, relayBaseDelay, relayDelay(1))
+	assert.Equal(t, 2*relayBaseDelay, relayDelay(2))
+	assert.Equal(t, relayMaxDelay, relayDelay(20))
+	assert.Equal(t, relayMaxDelay, relayDelay(1000))
+}
+
+// TestBookingEventsInOutbox проверяет на настоящем Postgres, что событие
+// пишется вместе с бронью, а отклонённая бронь события не оставляет.
+// Запускается, только если задан TEST_DATABASE_URL.
+func TestBookingEventsInOutbox(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	db = &GormDatabase{Conn: database}
+
+	room := Room{Name: fmt.Sprintf("Outbox Room %d", time.Now().UnixNano()), Active: true}
+	require.NoError(t, database.Create(&room).Error)
+	startedAt := time.Now()
+	t.Cleanup(func() {
+		database.Unscoped().Where("room_id = ?", room.ID).Delete(&Booking{})
+		database.Unscoped().Delete(&room)
+		database.Where("created_at >= ?", startedAt).Delete(&OutboxEvent{})
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/book", requireAuth(), createBooking)
+	r.DELETE("/bookings/:id", requireAuth(), deleteBooking)
+
+	request := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	start := time.Now().Add(72 * time.Hour).Truncate(time.Hour).UTC()
+	body := fmt.Sprintf(`{"room_id": %d, "start_time": %q, "end_time": %q}`,
+		room.ID, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/book", body))
+	require.Equal(t, http.StatusConflict, request(http.MethodPost, "/book", body))
+
+	var booking Booking
+	require.NoError(t, database.Where("room_id = ?", room.ID).First(&booking).Error)
+	require.Equal(t, http.StatusOK, request(http.MethodDelete, fmt.Sprintf("/bookings/%d", booking.ID), ""))
+
+	var stored []OutboxEvent
+	require.NoError(t, database.Where("created_at >= ? AND payload->>'room_id' = ?", startedAt, fmt.Sprint(room.ID)).Order("id").Find(&stored).Error)
+	require.Len(t, stored, 2)
+	assert.Equal(t, eventBookingCreated, stored[0].Type)
+	assert.Equal(t, eventBookingCancelled, stored[1].Type)
+	var event bookingEvent
+	require.NoError(t, json.Unmarshal([]byte(stored[1].Payload), &event))
+	assert.Equal(t, booking.ID, event.BookingID)
+	assert.Equal(t, uint(1), event.UserID)
+	assert.Equal(t, stored[1].EventID, event.ID)
+}
+
+// TestBulkCancellationEventsInOutbox проверяет на настоящем Postgres, что
+// серии и массовая отмена при удалении пользователя тоже пишут события —
+// по одному на каждую бронь. Запускается, только если задан TEST_DATABASE_URL.
+func TestBulkCancellationEventsInOutbox(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	db = &GormDatabase{Conn: database}
+
+	room := Room{Name: fmt.Sprintf("Bulk Room %d", time.Now().UnixNano()), Active: true}
+	require.NoError(t, database.Create(&room).Error)
+	// Отдельный пользователь, чтобы не отменить брони других тестов
+	userID := uint(time.Now().UnixNano()%1000000) + 1000000
+	startedAt := time.Now()
+	t.Cleanup(func() {
+		database.Unscoped().Where("room_id = ?", room.ID).Delete(&Booking{})
+		database.Unscoped().Where("room_id = ?", room.ID).Delete(&BookingSeries{})
+		database.Unscoped().Delete(&room)
+		database.Where("created_at >= ?", startedAt).Delete(&OutboxEvent{})
+	})
+
+	savedKey := internalAPIKey
+	defer func() { internalAPIKey = savedKey }()
+	internalAPIKey = "internal"
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/series", requireAuth(), createSeries)
+	r.DELETE("/series/:id", requireAuth(), deleteSeries)
+	r.POST("/internal/users/:id/cancel-bookings", requireInternalKey(), cancelUserBookings)
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+	eventTypes := func() []string {
+		var stored []OutboxEvent
+		require.NoError(t, database.Where("created_at >= ? AND payload->>'room_id' = ?", startedAt, fmt.Sprint(room.ID)).Order("id").Find(&stored).Error)
+		types := make([]string, len(stored))
+		for i, event := range stored {
+			types[i] = event.Type
+		}
+		return types
+	}
+	newSeries := func(start time.Time) uint {
+		body := fmt.Sprintf(`{"room_id": %d, "start_time": %q, "end_time": %q, "rrule": "FREQ=DAILY;COUNT=2"}`,
+			room.ID, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+		resp := request(http.MethodPost, "/series", signedToken(userID), body)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var created struct {
+			Series BookingSeries `json:"series"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+		return created.Series.ID
+	}
+	start := time.Now().Add(72 * time.Hour).Truncate(time.Hour).UTC()
+
+	seriesID := newSeries(start)
+	assert.Equal(t, []string{eventBookingCreated, eventBookingCreated}, eventTypes())
+
+	resp := request(http.MethodDelete, fmt.Sprintf("/series/%d", seriesID), signedToken(userID), "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, []string{eventBookingCreated, eventBookingCreated, eventBookingCancelled, eventBookingCancelled}, eventTypes())
+
+	newSeries(start.Add(4 * time.Hour))
+	resp = request(http.MethodPost, fmt.Sprintf("/internal/users/%d/cancel-bookings", userID), "internal", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.JSONEq(t, `{"cancelled": 2}`, resp.Body.String())
+	assert.Len(t, eventTypes(), 8)
+	assert.Equal(t, []string{eventBookingCancelled, eventBookingCancelled}, eventTypes()[6:])
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	icsTimeFormat = "20060102T150405Z"
+	// Отменённые и прошедшие брони за этот период ещё попадают в календарь,
+	// чтобы клиенты успели увидеть STATUS:CANCELLED.
+	calendarHistory = 90 * 24 * time.Hour
+)
+
+// CalendarFeed — секретная ссылка на календарь пользователя. Храним только
+// хэш токена, сам токен показывается один раз при создании.
+type CalendarFeed struct {
+	gorm.Model
+	UserID    uint   `json:"user_id" gorm:"uniqueIndex"`
+	TokenHash string `json:"-" gorm:"uniqueIndex"`
+}
+
+// icsEscape экранирует TEXT по RFC 5545, раздел 3.3.11.
+func icsEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// icsLine дописывает строку контента, сворачивая её по 75 октетов
+// и не разрывая символы UTF-8.
+func icsLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Пробел в начале продолжения занимает один октет
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// renderICS преобразует бронирования в VCALENDAR. UID строится из Booking.ID,
+// поэтому календарные приложения обновляют событие, а не дублируют его.
+func renderICS(bookings []Booking) []byte {
+	var b strings.Builder
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//booking//booking-service//EN")
+	icsLine(&b, "CALSCALE:GREGORIAN")
+	icsLine(&b, "METHOD:PUBLISH")
+	for _, booking := range bookings {
+		status := "CONFIRMED"
+		if booking.DeletedAt.Valid {
+			status = "CANCELLED"
+		}
+		modified := booking.UpdatedAt
+		if booking.DeletedAt.Valid {
+			modified = booking.DeletedAt.Time
+		}
+
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, fmt.Sprintf("UID:booking-%d@booking-service", booking.ID))
+		icsLine(&b, "DTSTAMP:"+modified.UTC().Format(icsTimeFormat))
+		icsLine(&b, "LAST-MODIFIED:"+modified.UTC().Format(icsTimeFormat))
+		icsLine(&b, "DTSTART:"+booking.StartTime.UTC().Format(icsTimeFormat))
+		icsLine(&b, "DTEND:"+booking.EndTime.UTC().Format(icsTimeFormat))
+		icsLine(&b, "SUMMARY:"+icsEscape("Booking: "+booking.RoomName))
+		icsLine(&b, "LOCATION:"+icsEscape(booking.RoomName))
+		icsLine(&b, "STATUS:"+status)
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// calendarBookings выбирает брони для календаря вместе с отменёнными. q —
+// выборка, уже ограниченная тем, что видно запрашивающему.
+func calendarBookings(q Database, roomID, userID uint) ([]Booking, error) {
+	q = q.Where("end_time > ?", time.Now().Add(-calendarHistory))
+	if roomID != 0 {
+		q = q.Where("room_id = ?", roomID)
+	}
+	if userID != 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+
+	var bookings []Booking
+	if err := q.Order("start_time ASC").Find(&bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+func writeICS(c *gin.Context, bookings []Booking) {
+	c.Header("Content-Disposition", `inline; filename="bookings.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", renderICS(bookings))
+}
+
+// exportICS отдаёт GET /bookings.ics с фильтрами room и user. Видны те же
+// брони, что и в GET /bookings.
+func exportICS(c *gin.Context) {
+	var roomID, userID uint
+	var err error
+	if v := c.Query("room"); v != "" {
+		if roomID, err = parseID(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+			return
+		}
+	}
+	if v := c.Query("user"); v != "" {
+		if userID, err = parseID(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user"})
+			return
+		}
+	}
+
+	bookings, err := calendarBookings(visibleBookings(db.Unscoped(), currentClaims(c)), roomID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve bookings"})
+		return
+	}
+	writeICS(c, bookings)
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createCalendarFeed выпускает (или перевыпускает) секретную ссылку на
+// календарь текущего пользователя. Старая ссылка перестаёт работать.
+func createCalendarFeed(c *gin.Context) {
+	claims := currentClaims(c)
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Generate feed token error: %v", err)
+		return
+	}
+	token := hex.EncodeToString(raw)
+
+	var feed CalendarFeed
+	err := db.Where("user_id = ?", claims.UserID).First(&feed)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find feed error: %v", err)
+		return
+	}
+	feed.UserID = claims.UserID
+	feed.TokenHash = hashFeedToken(token)
+	if err := db.Save(&feed); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Save feed error: %v", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": "/calendar/" + token + ".ics"})
+}
+
+// getCalendarFeed отдаёт календарь по секретной ссылке без заголовка
+// Authorization: календарные приложения его не передают.
+func getCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	var feed CalendarFeed
+	if err := db.Where("token_hash = ?", hashFeedToken(token)).First(&feed); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find feed error: %v", err)
+		}
+		return
+	}
+
+	// Ссылка ведёт только на брони её владельца
+	bookings, err := calendarBookings(db.Unscoped(), 0, feed.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve bookings"})
+		return
+	}
+	writeICS(c, bookings)
+}
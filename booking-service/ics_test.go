@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestRenderICS(t *testing.T) {
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	active := Booking{RoomName: "Room 1, east wing", StartTime: start, EndTime: start.Add(time.Hour)}
+	active.ID = 7
+	cancelled := Booking{RoomName: "Room 2", StartTime: start, EndTime: start.Add(time.Hour)}
+	cancelled.ID = 8
+	cancelled.DeletedAt = gorm.DeletedAt{Time: start, Valid: true}
+
+	ics := string(renderICS([]Booking{active, cancelled}))
+
+	assert.True(t, strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(ics, "END:VCALENDAR\r\n"))
+	assert.Contains(t, ics, "UID:booking-7@booking-service\r\n")
+	assert.Contains(t, ics, "DTSTART:20300107T090000Z\r\nDTEND:20300107T100000Z\r\n")
+	assert.Contains(t, ics, `SUMMARY:Booking: Room 1\, east wing`)
+	assert.Contains(t, ics, "UID:booking-8@booking-service\r\n")
+	assert.Equal(t, 1, strings.Count(ics, "STATUS:CANCELLED"))
+	assert.Equal(t, 1, strings.Count(ics, "STATUS:CONFIRMED"))
+}
+
+func TestICSLineFolding(t *testing.T) {
+	var b strings.Builder
+	icsLine(&b, "SUMMARY:"+strings.Repeat("ж", 60))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	assert.Greater(t, len(lines), 1)
+	for i, line := range lines {
+		assert.LessOrEqual(t, len(line), 75)
+		if i > 0 {
+			assert.True(t, strings.HasPrefix(line, " "))
+		}
+	}
+	unfolded := strings.ReplaceAll(b.String(), "\r\n ", "")
+	assert.Equal(t, "SUMMARY:"+strings.Repeat("ж", 60)+"\r\n", unfolded)
+}
+
+func TestGetCalendarFeed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/calendar/:token", getCalendarFeed)
+
+	t.Run("valid token", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "token_hash = ?", []interface{}{hashFeedToken("abc")}).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.CalendarFeed"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			args.Get(0).(*CalendarFeed).UserID = 5
+		})
+		mockDB.On("Unscoped").Return(mockDB)
+		mockDB.On("Where", "end_time > ?", mock.Anything).Return(mockDB)
+		mockDB.On("Where", "user_id = ?", []interface{}{uint(5)}).Return(mockDB)
+		mockDB.On("Order", "start_time ASC").Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]main.Booking"), mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/calendar/abc.ics", nil)
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "text/calendar; charset=utf-8", resp.Header().Get("Content-Type"))
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "token_hash = ?", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.CalendarFeed"), mock.Anything).Return(gorm.ErrRecordNotFound)
+
+		req, _ := http.NewRequest(http.MethodGet, "/calendar/nope.ics", nil)
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestExportICS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/bookings.ics", requireAuth(), exportICS)
+
+	export := func(token, query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/bookings.ics"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("filters are limited to visible bookings", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Unscoped").Return(mockDB)
+		mockDB.On("Where", "user_id = ?", []interface{}{uint(1)}).Return(mockDB).Once()
+		mockDB.On("Where", "end_time > ?", mock.Anything).Return(mockDB)
+		mockDB.On("Where", "user_id = ?", []interface{}{uint(2)}).Return(mockDB).Once()
+		mockDB.On("Order", "start_time ASC").Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]main.Booking"), mock.Anything).Return(nil)
+
+		resp := export(signedToken(1), "?user=2")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("admin sees any user's bookings", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Unscoped").Return(mockDB)
+		mockDB.On("Where", "end_time > ?", mock.Anything).Return(mockDB)
+		mockDB.On("Where", "user_id = ?", []interface{}{uint(2)}).Return(mockDB).Once()
+		mockDB.On("Order", "start_time ASC").Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]main.Booking"), mock.Anything).Return(nil)
+
+		resp := export(signedTokenWithRole(1, roleAdmin), "?user=2")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+}
@@ -6,7 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -19,6 +19,14 @@ import (
 
 var db Database
 
+var (
+	errRoomBooked      = errors.New("room is already booked for this time")
+	errBookingNotFound = errors.New("booking not found")
+	errForbidden       = errors.New("forbidden")
+	errBookingEnded    = errors.New("booking has already ended")
+	errTokenRevoked    = errors.New("token has been revoked")
+)
+
 // Определяем интерфейс для базы данных
 type Database interface {
 	AutoMigrate(models ...interface{}) error
@@ -26,7 +34,16 @@ type Database interface {
 	Where(query interface{}, args ...interface{}) Database
 	First(dest interface{}, conds ...interface{}) error
 	Find(dest interface{}, conds ...interface{}) error
+	Order(value interface{}) Database
+	Limit(limit int) Database
+	Unscoped() Database
+	Save(value interface{}) error
+	Delete(value interface{}, conds ...interface{}) error
 	Transaction(fc func(tx *gorm.DB) error) error
+	// ClaimOutboxEvents забирает до limit событий, готовых к отправке к at,
+	// и откладывает их следующую попытку до leaseUntil. Так одно событие не
+	// отправляют одновременно несколько реплик.
+	ClaimOutboxEvents(at, leaseUntil time.Time, limit int) ([]OutboxEvent, error)
 }
 
 type GormDatabase struct {
@@ -54,20 +71,46 @@ func (g *GormDatabase) Find(dest interface{}, conds ...interface{}) error {
 	return g.Conn.Find(dest, conds...).Error
 }
 
+func (g *GormDatabase) Order(value interface{}) Database {
+	return &GormDatabase{Conn: g.Conn.Order(value)}
+}
+
+func (g *GormDatabase) Limit(limit int) Database {
+	return &GormDatabase{Conn: g.Conn.Limit(limit)}
+}
+
+func (g *GormDatabase) Unscoped() Database {
+	return &GormDatabase{Conn: g.Conn.Unscoped()}
+}
+
+func (g *GormDatabase) Save(value interface{}) error {
+	return g.Conn.Save(value).Error
+}
+
+func (g *GormDatabase) Delete(value interface{}, conds ...interface{}) error {
+	return g.Conn.Delete(value, conds...).Error
+}
+
 func (g *GormDatabase) Transaction(fc func(tx *gorm.DB) error) error {
 	return g.Conn.Transaction(fc)
 }
 
 type Booking struct {
 	gorm.Model
+	RoomID    uint      `json:"room_id" gorm:"index"`
 	RoomName  string    `json:"room_name"`
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 	UserID    uint      `json:"user_id"`
+	SeriesID  *uint     `json:"series_id,omitempty" gorm:"index"`
 }
 
 type Claims struct {
-	UserID uint `json:"user_id"`
+	UserID uint   `json:"user_id"`
+	Role   string `json:"role"`
+	// Заполняются только для API-ключей, из JWT их взять нельзя
+	ServiceAccountID uint     `json:"-"`
+	Scopes           []string `json:"-"`
 	jwt.StandardClaims
 }
 
@@ -87,97 +130,359 @@ func initDB() {
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
-	database.AutoMigrate(&Booking{})
+	if err := migrate(database); err != nil {
+		log.Fatalf("failed to migrate the database: %v", err)
+	}
 	db = &GormDatabase{Conn: database}
 }
 
 func validateToken(tokenStr string) (*jwt.Token, error) {
 	claims := &Claims{}
-	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte("secret"), nil
+		return jwtKeys.KeyFor(token)
 	})
+	if err != nil {
+		return token, err
+	}
+	if claims.Id != "" && revoked.IsRevoked(claims.Id) {
+		return token, errTokenRevoked
+	}
+	return token, nil
 }
 
-func createBooking(c *gin.Context) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+// checkOverlap блокирует пересекающиеся бронирования той же комнаты и
+// возвращает *conflictError, если такие есть. Сама бронь (по ID) не учитывается,
+// чтобы её можно было переносить.
+func checkOverlap(tx *gorm.DB, booking *Booking) error {
+	var existingBooking Booking
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("room_id = ? AND start_time < ? AND end_time > ? AND id <> ?",
+		booking.RoomID, booking.EndTime, booking.StartTime, booking.ID).First(&existingBooking).Error
+	if err == nil {
+		return &conflictError{Requested: *booking, Existing: existingBooking}
 	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
 
-	tokenStr := strings.Split(authHeader, "Bearer ")[1]
-	token, err := validateToken(tokenStr)
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+// assignRoom привязывает бронирование к существующей активной комнате.
+// При ошибке отвечает сам и возвращает false.
+func assignRoom(c *gin.Context, booking *Booking, roomID uint, roomName string) bool {
+	room, err := resolveRoom(roomID, roomName)
+	if err != nil {
+		if errors.Is(err, errRoomNotFound) || errors.Is(err, errRoomInactive) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find room error: %v", err)
+		}
+		return false
 	}
+	booking.RoomID = room.ID
+	booking.RoomName = room.Name
+	return true
+}
+
+func createBooking(c *gin.Context) {
+	claims := currentClaims(c)
 
-	claims := token.Claims.(*Claims)
 	var booking Booking
 	if err := c.ShouldBindJSON(&booking); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if errs := rules.validate(booking.StartTime, booking.EndTime, true); len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
 	// Привязываем бронирование к пользователю
 	booking.UserID = claims.UserID
 
+	if !assignRoom(c, &booking, booking.RoomID, booking.RoomName) {
+		return
+	}
+
 	// Используем транзакцию для проверки и создания бронирования
-	err = db.Transaction(func(tx *gorm.DB) error {
-		var existingBooking Booking
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("room_name = ? AND start_time < ? AND end_time > ?",
-			booking.RoomName, booking.EndTime, booking.StartTime).First(&existingBooking).Error; err == nil {
-			return fmt.Errorf("room is already booked for this time")
-		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkOverlap(tx, &booking); err != nil {
 			return err
 		}
 
 		if err := tx.Create(&booking).Error; err != nil {
-			return err
+			return bookingWriteError(err, &booking)
 		}
-		return nil
+		return recordEvent(tx, eventBookingCreated, &booking, claims.UserID)
 	})
 
 	if err != nil {
-		if err.Error() == "room is already booked for this time" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if errors.Is(err, errRoomBooked) {
+			respondConflict(c, claims, err, &booking)
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 			log.Printf("Transaction error: %v", err)
 		}
 		return
 	}
+	wakeRelay()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Booking created successfully"})
 }
 
 func getBookings(c *gin.Context) {
+	filter, ok := parseBookingFilter(c)
+	if !ok {
+		return
+	}
+
 	var bookings []Booking
-	if err := db.Find(&bookings); err != nil {
+	if err := filter.apply(visibleBookings(db, currentClaims(c))).Find(&bookings); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve bookings"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"bookings": bookings})
+
+	var nextCursor *string
+	if len(bookings) > filter.Limit {
+		bookings = bookings[:filter.Limit]
+		last := bookings[len(bookings)-1]
+		cursor := bookingCursor{StartTime: last.StartTime, ID: last.ID}.encode()
+		nextCursor = &cursor
+	}
+	c.JSON(http.StatusOK, gin.H{"bookings": bookings, "next_cursor": nextCursor})
+}
+
+// deleteBooking отменяет бронирование. Кроме владельца это может сделать
+// администратор или управляющий комнатой. Запись удаляется мягко
+// (gorm.Model.DeletedAt), поэтому проверка пересечений её больше не видит.
+// Вместе с удалением в outbox пишется событие BookingCancelled.
+func deleteBooking(c *gin.Context) {
+	claims := currentClaims(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	var booking Booking
+	if err := db.First(&booking, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find booking error: %v", err)
+		}
+		return
+	}
+
+	allowed, err := canManage(claims, booking.UserID, booking.RoomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check room manager error: %v", err)
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&booking)
+		if result.Error != nil {
+			return result.Error
+		}
+		// Бронь уже отменили параллельным запросом: второе событие не нужно
+		if result.RowsAffected == 0 {
+			return errBookingNotFound
+		}
+		return recordEvent(tx, eventBookingCancelled, &booking, claims.UserID)
+	})
+	if err != nil {
+		if errors.Is(err, errBookingNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Delete booking error: %v", err)
+		}
+		return
+	}
+	wakeRelay()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Booking cancelled successfully"})
+}
+
+// bookingUpdate описывает изменяемые поля; отсутствующие поля не трогаем.
+type bookingUpdate struct {
+	RoomID    *uint      `json:"room_id"`
+	RoomName  *string    `json:"room_name"`
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+}
+
+// updateBooking переносит бронирование владельца, повторно проверяя
+// пересечения в той же транзакции, что и createBooking.
+func updateBooking(c *gin.Context) {
+	claims := currentClaims(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	var update bookingUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Новую комнату проверяем до транзакции, как и в createBooking
+	var target Booking
+	roomChanged := update.RoomID != nil || update.RoomName != nil
+	if roomChanged {
+		var roomID uint
+		var roomName string
+		if update.RoomID != nil {
+			roomID = *update.RoomID
+		}
+		if update.RoomName != nil {
+			roomName = *update.RoomName
+		}
+		if !assignRoom(c, &target, roomID, roomName) {
+			return
+		}
+	}
+
+	var booking Booking
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&booking, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errBookingNotFound
+			}
+			return err
+		}
+		allowed, err := canManage(claims, booking.UserID, booking.RoomID)
+		if err == nil && allowed && roomChanged && target.RoomID != booking.RoomID {
+			// Чужую бронь можно перенести только в комнату, которой тоже управляешь
+			allowed, err = canManage(claims, booking.UserID, target.RoomID)
+		}
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errForbidden
+		}
+		// Прошедшая бронь — история: ни комнату, ни время у неё не меняем
+		if !booking.EndTime.After(now()) {
+			return errBookingEnded
+		}
+
+		if roomChanged {
+			booking.RoomID = target.RoomID
+			booking.RoomName = target.RoomName
+		}
+		if update.StartTime != nil {
+			booking.StartTime = *update.StartTime
+		}
+		if update.EndTime != nil {
+			booking.EndTime = *update.EndTime
+		}
+		if errs := rules.validate(booking.StartTime, booking.EndTime, update.StartTime != nil); len(errs) > 0 {
+			return errs
+		}
+
+		if err := checkOverlap(tx, &booking); err != nil {
+			return err
+		}
+		if err := tx.Save(&booking).Error; err != nil {
+			return bookingWriteError(err, &booking)
+		}
+		return recordEvent(tx, eventBookingUpdated, &booking, claims.UserID)
+	})
+
+	if err != nil {
+		var verrs validationErrors
+		switch {
+		case errors.As(err, &verrs):
+			respondValidation(c, verrs)
+		case errors.Is(err, errBookingNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		case errors.Is(err, errForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		case errors.Is(err, errBookingEnded):
+			c.JSON(http.StatusConflict, gin.H{"error": "Booking has already ended"})
+		case errors.Is(err, errRoomBooked):
+			respondConflict(c, claims, err, &booking)
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Transaction error: %v", err)
+		}
+		return
+	}
+	wakeRelay()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Booking updated successfully", "booking": booking})
 }
 
 func main() {
+	keys, err := loadJWKS()
+	if err != nil {
+		log.Fatalf("failed to configure JWKS: %v", err)
+	}
+	jwtKeys = keys
+
+	loadBookingRules()
+	loadRevocationConfig()
+	loadAPIKeyConfig()
 	initDB()
+	loadOutboxConfig()
 	r := gin.Default()
 
 	// Добавление CORS middleware
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	r.POST("/book", createBooking)
-	r.GET("/bookings", getBookings)
+	// Маршруты со своей проверкой доступа: служебный секрет и секретная ссылка календаря
+	r.POST("/internal/revocations", requireInternalKey(), pushRevocation)
+	r.POST("/internal/users/:id/cancel-bookings", requireInternalKey(), cancelUserBookings)
+	r.GET("/calendar/:token", getCalendarFeed)
+
+	// Все остальные маршруты требуют bearer-токен
+	api := r.Group("/", requireAuth())
+
+	api.POST("/book", createBooking)
+	api.GET("/bookings", getBookings)
+	api.GET("/bookings.ics", exportICS)
+	api.PATCH("/bookings/:id", updateBooking)
+	api.DELETE("/bookings/:id", deleteBooking)
+
+	api.POST("/calendar", createCalendarFeed)
+
+	api.POST("/series", createSeries)
+	api.GET("/series/:id", getSeries)
+	api.DELETE("/series/:id", deleteSeries)
+
+	api.GET("/rooms", getRooms)
+	api.GET("/rooms/:id", getRoom)
+	api.POST("/rooms", requireAdmin(), createRoom)
+	api.PATCH("/rooms/:id", updateRoom)
+	api.DELETE("/rooms/:id", requireAdmin(), deleteRoom)
+	api.GET("/rooms/:id/managers", requireAdmin(), getRoomManagers)
+	api.PUT("/rooms/:id/managers/:user_id", requireAdmin(), addRoomManager)
+	api.DELETE("/rooms/:id/managers/:user_id", requireAdmin(), removeRoomManager)
+	api.GET("/rooms/:id/availability", getRoomAvailability)
+	api.GET("/availability", getAvailability)
+
 	r.Run(":8082")
 }
@@ -2,15 +2,21 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
+	"booking/shared/jwks"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 type MockDatabase struct {
@@ -27,7 +33,7 @@ func (m *MockDatabase) Create(value interface{}) error {
 }
 
 func (m *MockDatabase) Where(query interface{}, args ...interface{}) Database {
-	args := m.Called(query, args)
+	m.Called(query, args)
 	return m
 }
 
@@ -41,28 +47,110 @@ func (m *MockDatabase) Find(dest interface{}, conds ...interface{}) error {
 	return args.Error(0)
 }
 
+func (m *MockDatabase) Order(value interface{}) Database {
+	m.Called(value)
+	return m
+}
+
+func (m *MockDatabase) Limit(limit int) Database {
+	m.Called(limit)
+	return m
+}
+
+func (m *MockDatabase) Unscoped() Database {
+	m.Called()
+	return m
+}
+
+func (m *MockDatabase) Save(value interface{}) error {
+	args := m.Called(value)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) Delete(value interface{}, conds ...interface{}) error {
+	args := m.Called(value, conds)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) Transaction(fc func(tx *gorm.DB) error) error {
+	args := m.Called(fc)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ClaimOutboxEvents(at, leaseUntil time.Time, limit int) ([]OutboxEvent, error) {
+	args := m.Called(at, leaseUntil, limit)
+	events, _ := args.Get(0).([]OutboxEvent)
+	return events, args.Error(1)
+}
+
+// mockActiveRoom настраивает поиск комнаты по ID.
+func mockActiveRoom(mockDB *MockDatabase, active bool) {
+	mockDB.On("First", mock.AnythingOfType("*main.Room"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		room := args.Get(0).(*Room)
+		room.ID = 1
+		room.Name = "Room1"
+		room.Active = active
+	})
+}
+
+// mockConflictLookups настраивает запросы, которые делает respondConflict.
+func mockConflictLookups(mockDB *MockDatabase) {
+	mockDB.On("Where", "room_id = ? AND start_time < ? AND end_time > ? AND id <> ?", mock.Anything).Return(mockDB).Maybe()
+	mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(gorm.ErrRecordNotFound).Maybe()
+	mockDB.On("Find", mock.AnythingOfType("*[]main.Booking"), mock.Anything).Return(nil).Maybe()
+}
+
+var testKey *rsa.PrivateKey
+
+func TestMain(m *testing.M) {
+	var err error
+	testKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	jwtKeys = jwks.New("")
+	jwtKeys.AddKey("test", &testKey.PublicKey)
+	os.Exit(m.Run())
+}
+
+func signedToken(userID uint) string {
+	return signedTokenWithKey(userID, "test", testKey)
+}
+
+func signedTokenWithKey(userID uint, kid string, key *rsa.PrivateKey) string {
+	return signClaims(Claims{UserID: userID}, kid, key)
+}
+
+func signedTokenWithRole(userID uint, role string) string {
+	return signClaims(Claims{UserID: userID, Role: role}, "test", testKey)
+}
+
+func signClaims(claims Claims, kid string, key *rsa.PrivateKey) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	tokenString, _ := token.SignedString(key)
+	return tokenString
+}
+
 func TestCreateBooking(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	r := gin.Default()
-	mockDB := new(MockDatabase)
-	db = mockDB
+	r.POST("/book", requireAuth(), createBooking)
 
-	r.POST("/book", createBooking)
+	slotStart := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
 
 	t.Run("successfully create booking", func(t *testing.T) {
-		booking := &Booking{RoomName: "Room1", StartTime: time.Now(), EndTime: time.Now().Add(1 * time.Hour), UserID: 1}
-		mockDB.On("Where", "room_name = ? AND start_time < ? AND end_time > ?", mock.Anything, mock.Anything, mock.Anything).Return(mockDB)
-		mockDB.On("First", mock.Anything, mock.Anything).Return(gorm.ErrRecordNotFound)
-		mockDB.On("Create", mock.AnythingOfType("*main.Booking")).Return(nil)
+		mockDB := new(MockDatabase)
+		db = mockDB
 
-		claims := Claims{UserID: 1}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, _ := token.SignedString([]byte("secret"))
+		booking := &Booking{RoomID: 1, StartTime: slotStart, EndTime: slotStart.Add(1 * time.Hour), UserID: 1}
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Transaction", mock.Anything).Return(nil)
 
 		jsonBooking, _ := json.Marshal(booking)
 		req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBuffer(jsonBooking))
-		req.Header.Set("Authorization", "Bearer "+tokenString)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
 		resp := httptest.NewRecorder()
 
 		r.ServeHTTP(resp, req)
@@ -73,28 +161,104 @@ func TestCreateBooking(t *testing.T) {
 	})
 
 	t.Run("booking conflict", func(t *testing.T) {
-		booking := &Booking{RoomName: "Room1", StartTime: time.Now(), EndTime: time.Now().Add(1 * time.Hour), UserID: 1}
-		mockDB.On("Where", "room_name = ? AND start_time < ? AND end_time > ?", mock.Anything, mock.Anything, mock.Anything).Return(mockDB)
-		mockDB.On("First", mock.Anything, mock.Anything).Return(nil) // Pretend we found a conflict
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		booking := &Booking{RoomID: 1, StartTime: slotStart, EndTime: slotStart.Add(1 * time.Hour), UserID: 1}
+		existing := Booking{RoomID: 1, StartTime: slotStart.Add(-30 * time.Minute), EndTime: slotStart.Add(30 * time.Minute), UserID: 2}
+		existing.ID = 9
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Transaction", mock.Anything).Return(&conflictError{Requested: *booking, Existing: existing})
+		mockDB.On("Where", "room_id = ? AND start_time < ? AND end_time > ? AND id <> ?", mock.Anything).Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*[]Booking) = []Booking{existing}
+		})
 
-		claims := Claims{UserID: 1}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, _ := token.SignedString([]byte("secret"))
+		jsonBooking, _ := json.Marshal(booking)
+		req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBuffer(jsonBooking))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		var response struct {
+			Error       string                 `json:"error"`
+			Conflict    map[string]interface{} `json:"conflict"`
+			Suggestions []timeSlot             `json:"suggestions"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		assert.Equal(t, "room is already booked for this time", response.Error)
+		assert.Equal(t, float64(9), response.Conflict["booking_id"])
+		assert.NotContains(t, response.Conflict, "user_id")
+		assert.Len(t, response.Suggestions, 3)
+		// Ближайшие слоты: сразу после чужой брони и вплотную перед ней
+		assert.True(t, response.Suggestions[0].StartTime.Equal(slotStart.Add(30*time.Minute)))
+		assert.True(t, response.Suggestions[1].EndTime.Equal(slotStart.Add(-30*time.Minute)))
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("invalid time range", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
 
+		booking := &Booking{RoomID: 1, StartTime: slotStart.Add(7 * time.Minute), EndTime: slotStart.Add(-time.Hour)}
 		jsonBooking, _ := json.Marshal(booking)
 		req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBuffer(jsonBooking))
-		req.Header.Set("Authorization", "Bearer "+tokenString)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
 		resp := httptest.NewRecorder()
 
 		r.ServeHTTP(resp, req)
 
 		assert.Equal(t, http.StatusBadRequest, resp.Code)
-		assert.JSONEq(t, `{"error": "Room is already booked for this time"}`, resp.Body.String())
-		mockDB.AssertExpectations(t)
+		assert.JSONEq(t, `{"error": "Invalid booking time", "fields": [
+			{"field": "end_time", "message": "must be after start_time"},
+			{"field": "start_time", "message": "must be aligned to 15m0s"}
+		]}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+	})
+
+	t.Run("unknown room name", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "LOWER(name) = LOWER(?)", []interface{}{"Room 404"}).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.Room"), mock.Anything).Return(gorm.ErrRecordNotFound)
+
+		booking := &Booking{RoomName: "  Room   404 ", StartTime: slotStart, EndTime: slotStart.Add(1 * time.Hour)}
+		jsonBooking, _ := json.Marshal(booking)
+		req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBuffer(jsonBooking))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "unknown room"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+	})
+
+	t.Run("inactive room", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, false)
+
+		booking := &Booking{RoomID: 1, StartTime: slotStart, EndTime: slotStart.Add(1 * time.Hour)}
+		jsonBooking, _ := json.Marshal(booking)
+		req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBuffer(jsonBooking))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "room is inactive"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
 	})
 
 	t.Run("unauthorized request", func(t *testing.T) {
-		booking := &Booking{RoomName: "Room1", StartTime: time.Now(), EndTime: time.Now().Add(1 * time.Hour), UserID: 1}
+		booking := &Booking{RoomName: "Room1", StartTime: slotStart, EndTime: slotStart.Add(1 * time.Hour), UserID: 1}
 
 		jsonBooking, _ := json.Marshal(booking)
 		req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBuffer(jsonBooking))
@@ -111,28 +275,341 @@ func TestGetBookings(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	r := gin.Default()
-	mockDB := new(MockDatabase)
-	db = mockDB
-
-	r.GET("/bookings", getBookings)
+	r.GET("/bookings", requireAuth(), getBookings)
 
 	t.Run("fetch bookings", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
 		booking := Booking{RoomName: "Room1", StartTime: time.Now(), EndTime: time.Now().Add(1 * time.Hour), UserID: 1}
+		mockDB.On("Where", "user_id = ?", []interface{}{uint(1)}).Return(mockDB)
+		mockDB.On("Order", "start_time ASC, id ASC").Return(mockDB)
+		mockDB.On("Limit", defaultPageSize+1).Return(mockDB)
 		mockDB.On("Find", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 			arg := args.Get(0).(*[]Booking)
 			*arg = append(*arg, booking)
 		})
 
 		req, _ := http.NewRequest(http.MethodGet, "/bookings", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
 		resp := httptest.NewRecorder()
 
 		r.ServeHTTP(resp, req)
 
-		expectedResponse := gin.H{"bookings": []Booking{booking}}
+		expectedResponse := gin.H{"bookings": []Booking{booking}, "next_cursor": nil}
 		responseJSON, _ := json.Marshal(expectedResponse)
 
 		assert.Equal(t, http.StatusOK, resp.Code)
 		assert.JSONEq(t, string(responseJSON), resp.Body.String())
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("visibility depends on role", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			role  string
+			where func(mockDB *MockDatabase)
+		}{
+			{"admin sees everything", roleAdmin, func(*MockDatabase) {}},
+			{"room manager sees own and managed rooms", roleRoomManager, func(mockDB *MockDatabase) {
+				mockDB.On("Where", "user_id = ? OR room_id IN (SELECT room_id FROM room_managers WHERE user_id = ?)",
+					[]interface{}{uint(4), uint(4)}).Return(mockDB).Once()
+			}},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				mockDB := new(MockDatabase)
+				db = mockDB
+				tc.where(mockDB)
+				mockDB.On("Order", mock.Anything).Return(mockDB)
+				mockDB.On("Limit", mock.Anything).Return(mockDB)
+				mockDB.On("Find", mock.Anything, mock.Anything).Return(nil)
+
+				req, _ := http.NewRequest(http.MethodGet, "/bookings", nil)
+				req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(4, tc.role))
+				resp := httptest.NewRecorder()
+
+				r.ServeHTTP(resp, req)
+
+				assert.Equal(t, http.StatusOK, resp.Code)
+				mockDB.AssertExpectations(t)
+			})
+		}
+	})
+
+	t.Run("filters and paginates own bookings", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		start := time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC)
+		cursor := bookingCursor{StartTime: start, ID: 5}
+
+		mockDB.On("Where", "room_id = ?", []interface{}{uint(3)}).Return(mockDB)
+		mockDB.On("Where", "user_id = ?", []interface{}{uint(1)}).Return(mockDB)
+		mockDB.On("Where", "end_time > ?", mock.Anything).Return(mockDB)
+		mockDB.On("Where", "start_time < ? OR (start_time = ? AND id < ?)", []interface{}{start, start, uint(5)}).Return(mockDB)
+		mockDB.On("Order", "start_time DESC, id DESC").Return(mockDB)
+		mockDB.On("Limit", 3).Return(mockDB)
+		mockDB.On("Find", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*[]Booking)
+			for i := 0; i < 3; i++ {
+				b := Booking{RoomID: 3, UserID: 1, StartTime: start.Add(-time.Duration(i+1) * time.Hour)}
+				b.ID = uint(4 - i)
+				*arg = append(*arg, b)
+			}
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, "/bookings?room=3&mine=true&from=2029-12-01T00:00:00Z&sort=desc&limit=2&cursor="+cursor.encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response struct {
+			Bookings   []Booking `json:"bookings"`
+			NextCursor string    `json:"next_cursor"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		assert.Len(t, response.Bookings, 2)
+		next, err := decodeCursor(response.NextCursor)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(3), next.ID)
+		assert.True(t, next.StartTime.Equal(start.Add(-2*time.Hour)))
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/bookings?mine=true", nil)
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Equal(t, `Bearer realm="booking-service"`, resp.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("invalid sort order", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/bookings?sort=sideways", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "Invalid sort order"}`, resp.Body.String())
+	})
+}
+
+func TestDeleteBooking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.DELETE("/bookings/:id", requireAuth(), deleteBooking)
+
+	t.Run("owner cancels booking", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), []interface{}{uint64(7)}).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*Booking)
+			arg.ID = 7
+			arg.UserID = 1
+		})
+		mockDB.On("Transaction", mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"message": "Booking cancelled successfully"}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("not the owner", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*Booking)
+			arg.ID = 7
+			arg.UserID = 2
+		})
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.JSONEq(t, `{"error": "Forbidden"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+	})
+
+	t.Run("admin cancels any booking", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*Booking)
+			arg.ID = 7
+			arg.UserID = 2
+		})
+		mockDB.On("Transaction", mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("room manager cancels booking in their room", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*Booking)
+			arg.ID = 7
+			arg.RoomID = 3
+			arg.UserID = 2
+		})
+		mockDB.On("Where", "room_id = ? AND user_id = ?", []interface{}{uint(3), uint(1)}).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.RoomManager"), mock.Anything).Return(nil)
+		mockDB.On("Transaction", mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleRoomManager))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("room manager of another room", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*Booking)
+			arg.ID = 7
+			arg.RoomID = 3
+			arg.UserID = 2
+		})
+		mockDB.On("Where", "room_id = ? AND user_id = ?", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.RoomManager"), mock.Anything).Return(gorm.ErrRecordNotFound)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleRoomManager))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+	})
+
+	t.Run("booking cancelled by a concurrent request", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).(*Booking)
+			arg.ID = 7
+			arg.UserID = 1
+		})
+		mockDB.On("Transaction", mock.Anything).Return(errBookingNotFound)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("booking not found", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Booking"), mock.Anything).Return(gorm.ErrRecordNotFound)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/42", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		assert.JSONEq(t, `{"error": "Booking not found"}`, resp.Body.String())
+	})
+
+	t.Run("unauthorized request", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "/bookings/7", nil)
+		req.Header.Set("Authorization", "Token abc")
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func TestUpdateBooking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.PATCH("/bookings/:id", requireAuth(), updateBooking)
+
+	body := `{"start_time": "2030-01-01T10:00:00Z", "end_time": "2030-01-01T11:00:00Z"}`
+
+	cases := []struct {
+		name     string
+		txErr    error
+		wantCode int
+	}{
+		{"successfully reschedule", nil, http.StatusOK},
+		{"slot already taken", errRoomBooked, http.StatusConflict},
+		{"booking not found", errBookingNotFound, http.StatusNotFound},
+		{"not the owner", errForbidden, http.StatusForbidden},
+		{"booking has already ended", errBookingEnded, http.StatusConflict},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(MockDatabase)
+			db = mockDB
+			mockDB.On("Transaction", mock.Anything).Return(tc.txErr)
+			mockConflictLookups(mockDB)
+
+			req, _ := http.NewRequest(http.MethodPatch, "/bookings/7", bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "Bearer "+signedToken(1))
+			resp := httptest.NewRecorder()
+
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			mockDB.AssertExpectations(t)
+		})
+	}
+
+	t.Run("invalid body", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPatch, "/bookings/7", bytes.NewBufferString(`{"start_time": "tomorrow"}`))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// SQLSTATE exclusion_violation и unique_violation в Postgres.
+const (
+	exclusionViolation = "23P01"
+	uniqueViolation    = "23505"
+)
+
+// roomNameIndex заменяет прежний уникальный индекс по name: тот различал
+// регистр, хотя поиск идёт по LOWER(name), и учитывал удалённые комнаты,
+// так что их имя нельзя было занять снова.
+var roomNameIndex = []string{
+	"DROP INDEX IF EXISTS idx_rooms_name",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_rooms_name_lower ON rooms (LOWER(name)) WHERE deleted_at IS NULL",
+}
+
+// noOverlapConstraint запрещает пересечение броней одной комнаты на уровне БД.
+// SELECT ... FOR UPDATE в checkOverlap ничего не блокирует, если пересекающейся
+// строки ещё нет, и два параллельных запроса на свободный слот оба проходят
+// проверку. Ограничение гарантирует, что вставится только один. Диапазон
+// tstzrange по умолчанию полуоткрытый [start, end), как и проверка в коде;
+// мягко удалённые брони не учитываются. Не учитываются и старые брони, для
+// которых backfill не нашёл комнату (room_id = 0): иначе брони разных
+// комнат считались бы одной, и ограничение не удалось бы добавить.
+// Ограничение прежней версии, без условия на room_id, пересоздаётся.
+const noOverlapConstraint = `
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bookings_no_overlap'
+			AND pg_get_constraintdef(oid) NOT LIKE '%room_id <> 0%') THEN
+		ALTER TABLE bookings DROP CONSTRAINT bookings_no_overlap;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bookings_no_overlap') THEN
+		ALTER TABLE bookings ADD CONSTRAINT bookings_no_overlap
+			EXCLUDE USING gist (room_id WITH =, tstzrange(start_time, end_time) WITH &&)
+			WHERE (deleted_at IS NULL AND room_id <> 0);
+	END IF;
+END
+$$;`
+
+// Брони, созданные до каталога комнат, хранят только room_name, а room_id у
+// них 0 или NULL. Для каждого такого имени заводим комнату (если её ещё нет) и
+// проставляем room_id, иначе старые брони не мешали бы новым в той же
+// комнате. Имена сравниваются так же, как в normalizeRoomName.
+const (
+	backfillRooms = `
+INSERT INTO rooms (name, active, created_at, updated_at)
+SELECT DISTINCT ON (LOWER(name)) name, true, NOW(), NOW()
+FROM (SELECT regexp_replace(trim(room_name), '\s+', ' ', 'g') AS name
+      FROM bookings WHERE COALESCE(room_id, 0) = 0) legacy
+WHERE name <> ''
+  AND NOT EXISTS (SELECT 1 FROM rooms r WHERE LOWER(r.name) = LOWER(legacy.name))
+ORDER BY LOWER(name), name
+ON CONFLICT (LOWER(name)) WHERE deleted_at IS NULL DO NOTHING`
+	backfillBookingRooms = `
+UPDATE bookings b SET room_id = r.id, room_name = r.name
+FROM rooms r
+WHERE COALESCE(b.room_id, 0) = 0
+  AND LOWER(r.name) = LOWER(regexp_replace(trim(b.room_name), '\s+', ' ', 'g'))`
+)
+
+// backfillOutboxBookings проставляет booking_id неотправленным событиям,
+// записанным до появления колонки: без него порядок событий брони не
+// соблюдается.
+const backfillOutboxBookings = `
+UPDATE outbox_events SET booking_id = (payload->>'booking_id')::bigint
+WHERE delivered_at IS NULL AND COALESCE(booking_id, 0) = 0`
+
+// Строки, из-за которых bookings_no_overlap не добавить: пересечения,
+// пропущенные прежней проверкой без блокировок, и брони с end_time раньше
+// start_time, которые принимал API до проверки правил (на них tstzrange
+// падает с ошибкой). Условие то же, что у ограничения.
+const (
+	overlapReportLimit = 100
+
+	overlappingBookings = `
+SELECT a.id AS first, b.id AS second
+FROM bookings a JOIN bookings b
+  ON a.room_id = b.room_id AND a.id < b.id
+ AND a.start_time < b.end_time AND b.start_time < a.end_time
+WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL AND a.room_id <> 0
+  AND a.start_time <= a.end_time AND b.start_time <= b.end_time
+ORDER BY a.id, b.id
+LIMIT ?`
+	invertedBookings = `
+SELECT id FROM bookings
+WHERE deleted_at IS NULL AND room_id <> 0 AND end_time < start_time
+ORDER BY id
+LIMIT ?`
+)
+
+// checkOverlapData проверяет, что ограничение можно добавить. Если нет,
+// возвращает ошибку с ID броней, которые нужно исправить или отменить
+// вручную: решать за пользователей, чья бронь важнее, миграция не должна.
+func checkOverlapData(database *gorm.DB) error {
+	var ready bool
+	if err := database.Raw(`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bookings_no_overlap'
+		AND pg_get_constraintdef(oid) LIKE '%room_id <> 0%')`).Scan(&ready).Error; err != nil {
+		return err
+	}
+	if ready {
+		return nil
+	}
+
+	var pairs []struct{ First, Second uint }
+	if err := database.Raw(overlappingBookings, overlapReportLimit).Scan(&pairs).Error; err != nil {
+		return err
+	}
+	var inverted []uint
+	if err := database.Raw(invertedBookings, overlapReportLimit).Scan(&inverted).Error; err != nil {
+		return err
+	}
+	if len(pairs) == 0 && len(inverted) == 0 {
+		return nil
+	}
+
+	var problems []string
+	if len(pairs) > 0 {
+		list := make([]string, len(pairs))
+		for i, p := range pairs {
+			list[i] = fmt.Sprintf("%d and %d", p.First, p.Second)
+		}
+		problems = append(problems, "overlapping bookings: "+strings.Join(list, ", "))
+	}
+	if len(inverted) > 0 {
+		list := make([]string, len(inverted))
+		for i, id := range inverted {
+			list[i] = fmt.Sprint(id)
+		}
+		problems = append(problems, "bookings ending before they start: "+strings.Join(list, ", "))
+	}
+	return fmt.Errorf("cannot add bookings_no_overlap, fix or cancel these bookings first (at most %d of each are listed): %s",
+		overlapReportLimit, strings.Join(problems, "; "))
+}
+
+func migrate(database *gorm.DB) error {
+	if err := database.AutoMigrate(&Room{}, &RoomManager{}, &BookingSeries{}, &Booking{}, &CalendarFeed{}, &OutboxEvent{}); err != nil {
+		return err
+	}
+	for _, stmt := range roomNameIndex {
+		if err := database.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	if err := database.Exec(backfillRooms).Error; err != nil {
+		return err
+	}
+	if err := database.Exec(backfillBookingRooms).Error; err != nil {
+		return err
+	}
+	if err := database.Exec(backfillOutboxBookings).Error; err != nil {
+		return err
+	}
+	if err := database.Exec("CREATE EXTENSION IF NOT EXISTS btree_gist").Error; err != nil {
+		return err
+	}
+	if err := checkOverlapData(database); err != nil {
+		return err
+	}
+	return database.Exec(noOverlapConstraint).Error
+}
+
+// isUniqueViolation сообщает, что запись нарушила уникальный индекс.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// bookingWriteError превращает нарушение bookings_no_overlap в *conflictError,
+// чтобы гонка, пойманная базой, выглядела для клиента так же, как обычный конфликт.
+func bookingWriteError(err error, booking *Booking) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		return &conflictError{Requested: *booking}
+	}
+	return err
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestBookingWriteError(t *testing.T) {
+	booking := &Booking{RoomID: 1}
+	assert.ErrorIs(t, bookingWriteError(&pgconn.PgError{Code: exclusionViolation}, booking), errRoomBooked)
+	assert.NotErrorIs(t, bookingWriteError(&pgconn.PgError{Code: "23505"}, booking), errRoomBooked)
+	assert.NoError(t, bookingWriteError(nil, booking))
+}
+
+// TestConcurrentBookingOneWinner проверяет на настоящем Postgres, что из
+// параллельных запросов на один свободный слот успешен ровно один.
+// Запускается, только если задан TEST_DATABASE_URL.
+func TestConcurrentBookingOneWinner(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	db = &GormDatabase{Conn: database}
+
+	room := Room{Name: fmt.Sprintf("Race Room %d", time.Now().UnixNano()), Active: true}
+	require.NoError(t, database.Create(&room).Error)
+	t.Cleanup(func() {
+		database.Unscoped().Where("room_id = ?", room.ID).Delete(&Booking{})
+		database.Unscoped().Delete(&room)
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/book", requireAuth(), createBooking)
+
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Hour).UTC()
+	body := fmt.Sprintf(`{"room_id": %d, "start_time": %q, "end_time": %q}`,
+		room.ID, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+
+	const requests = 20
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPost, "/book", bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "Bearer "+signedToken(1))
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+			codes <- resp.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusConflict: requests - 1}, counts)
+
+	var stored int64
+	database.Model(&Booking{}).Where("room_id = ?", room.ID).Count(&stored)
+	assert.Equal(t, int64(1), stored)
+}
+
+// TestMigrateBackfillsLegacyRooms проверяет, что брони без room_id после
+// миграции привязываются к комнате по имени и снова участвуют в проверке
+// пересечений. Запускается, только если задан TEST_DATABASE_URL.
+func TestMigrateBackfillsLegacyRooms(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+
+	name := fmt.Sprintf("Legacy Room %d", time.Now().UnixNano())
+	start := time.Now().Add(96 * time.Hour).Truncate(time.Hour).UTC()
+	legacy := []Booking{
+		{RoomName: name, StartTime: start, EndTime: start.Add(time.Hour), UserID: 1},
+		{RoomName: " " + strings.ToUpper(name) + " ", StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour), UserID: 2},
+	}
+	require.NoError(t, database.Create(&legacy).Error)
+	t.Cleanup(func() {
+		database.Unscoped().Where("id IN ?", []uint{legacy[0].ID, legacy[1].ID}).Delete(&Booking{})
+		database.Unscoped().Where("LOWER(name) = LOWER(?)", name).Delete(&Room{})
+	})
+
+	require.NoError(t, migrate(database))
+
+	var room Room
+	require.NoError(t, database.Where("LOWER(name) = LOWER(?)", name).First(&room).Error)
+	assert.True(t, room.Active)
+	var backfilled []Booking
+	require.NoError(t, database.Where("id IN ?", []uint{legacy[0].ID, legacy[1].ID}).Find(&backfilled).Error)
+	for _, b := range backfilled {
+		assert.Equal(t, room.ID, b.RoomID)
+	}
+
+	// Старая бронь снова занимает слот своей комнаты
+	clash := Booking{RoomID: room.ID, RoomName: room.Name, StartTime: start, EndTime: start.Add(time.Hour), UserID: 3}
+	err = database.Transaction(func(tx *gorm.DB) error { return checkOverlap(tx, &clash) })
+	assert.ErrorIs(t, err, errRoomBooked)
+}
+
+// TestMigrateIgnoresRoomlessBookings проверяет, что пересекающиеся брони без
+// комнаты не мешают миграции. Запускается, только если задан TEST_DATABASE_URL.
+func TestMigrateIgnoresRoomlessBookings(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+
+	start := time.Now().Add(120 * time.Hour).Truncate(time.Hour).UTC()
+	roomless := []Booking{
+		{StartTime: start, EndTime: start.Add(time.Hour), UserID: 1},
+		{StartTime: start, EndTime: start.Add(time.Hour), UserID: 2},
+	}
+	require.NoError(t, database.Create(&roomless).Error)
+	t.Cleanup(func() {
+		database.Unscoped().Where("id IN ?", []uint{roomless[0].ID, roomless[1].ID}).Delete(&Booking{})
+	})
+
+	assert.NoError(t, migrate(database))
+}
+
+// TestMigrateReportsConflictingLegacyBookings проверяет, что миграция не
+// падает на середине, а называет брони, мешающие bookings_no_overlap.
+// Запускается, только если задан TEST_DATABASE_URL.
+func TestMigrateReportsConflictingLegacyBookings(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+
+	room := Room{Name: fmt.Sprintf("Conflict Room %d", time.Now().UnixNano()), Active: true}
+	require.NoError(t, database.Create(&room).Error)
+	// Такие строки могли остаться от старых версий, в которых ограничения не было
+	require.NoError(t, database.Exec("ALTER TABLE bookings DROP CONSTRAINT bookings_no_overlap").Error)
+	start := time.Now().Add(144 * time.Hour).Truncate(time.Hour).UTC()
+	legacy := []Booking{
+		{RoomID: room.ID, RoomName: room.Name, StartTime: start, EndTime: start.Add(time.Hour), UserID: 1},
+		{RoomID: room.ID, RoomName: room.Name, StartTime: start.Add(30 * time.Minute), EndTime: start.Add(2 * time.Hour), UserID: 2},
+		{RoomID: room.ID, RoomName: room.Name, StartTime: start.Add(5 * time.Hour), EndTime: start.Add(4 * time.Hour), UserID: 3},
+	}
+	require.NoError(t, database.Create(&legacy).Error)
+	t.Cleanup(func() {
+		database.Unscoped().Where("room_id = ?", room.ID).Delete(&Booking{})
+		database.Unscoped().Delete(&room)
+		require.NoError(t, migrate(database))
+	})
+
+	err = migrate(database)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("overlapping bookings: %d and %d", legacy[0].ID, legacy[1].ID))
+	assert.Contains(t, err.Error(), fmt.Sprintf("bookings ending before they start: %d", legacy[2].ID))
+
+	// После исправления данных миграция проходит и ограничение добавляется
+	require.NoError(t, database.Delete(&legacy[1]).Error)
+	require.NoError(t, database.Model(&legacy[2]).Update("end_time", start.Add(6*time.Hour)).Error)
+	require.NoError(t, migrate(database))
+	var constraints int64
+	database.Raw("SELECT COUNT(*) FROM pg_constraint WHERE conname = 'bookings_no_overlap'").Scan(&constraints)
+	assert.Equal(t, int64(1), constraints)
+}
+
+// TestRoomManagerReschedulesBooking проверяет, что управляющий комнатой
+// переносит чужую бронь внутри своих комнат, но не в чужую комнату.
+// Запускается, только если задан TEST_DATABASE_URL.
+func TestRoomManagerReschedulesBooking(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	db = &GormDatabase{Conn: database}
+
+	suffix := time.Now().UnixNano()
+	managed := Room{Name: fmt.Sprintf("Managed Room %d", suffix), Active: true}
+	other := Room{Name: fmt.Sprintf("Other Room %d", suffix), Active: true}
+	require.NoError(t, database.Create(&managed).Error)
+	require.NoError(t, database.Create(&other).Error)
+	require.NoError(t, database.Create(&RoomManager{RoomID: managed.ID, UserID: 2}).Error)
+	start := time.Now().Add(96 * time.Hour).Truncate(time.Hour).UTC()
+	booking := Booking{UserID: 1, RoomID: managed.ID, RoomName: managed.Name, StartTime: start, EndTime: start.Add(time.Hour)}
+	require.NoError(t, database.Create(&booking).Error)
+	t.Cleanup(func() {
+		database.Unscoped().Where("room_id IN ?", []uint{managed.ID, other.ID}).Delete(&Booking{})
+		database.Where("room_id = ?", managed.ID).Delete(&RoomManager{})
+		database.Unscoped().Delete(&managed)
+		database.Unscoped().Delete(&other)
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PATCH("/bookings/:id", requireAuth(), updateBooking)
+	update := func(token, body string) int {
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("/bookings/%d", booking.ID), bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	later := start.Add(2 * time.Hour)
+	reschedule := fmt.Sprintf(`{"start_time": %q, "end_time": %q}`,
+		later.Format(time.RFC3339), later.Add(time.Hour).Format(time.RFC3339))
+
+	assert.Equal(t, http.StatusForbidden, update(signedToken(3), reschedule))
+	assert.Equal(t, http.StatusOK, update(signedTokenWithRole(2, roleRoomManager), reschedule))
+	assert.Equal(t, http.StatusForbidden, update(signedTokenWithRole(2, roleRoomManager), fmt.Sprintf(`{"room_id": %d}`, other.ID)))
+
+	var stored Booking
+	require.NoError(t, database.First(&stored, booking.ID).Error)
+	assert.Equal(t, managed.ID, stored.RoomID)
+	assert.True(t, later.Equal(stored.StartTime))
+}
+
+// TestUpdateEndedBooking проверяет, что у прошедшей брони нельзя сменить
+// комнату или продлить её: запрос без start_time не проверяет прошлое
+// в правилах. Запускается, только если задан TEST_DATABASE_URL.
+func TestUpdateEndedBooking(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	db = &GormDatabase{Conn: database}
+
+	suffix := time.Now().UnixNano()
+	room := Room{Name: fmt.Sprintf("History Room %d", suffix), Active: true}
+	other := Room{Name: fmt.Sprintf("Spare Room %d", suffix), Active: true}
+	require.NoError(t, database.Create(&room).Error)
+	require.NoError(t, database.Create(&other).Error)
+	start := time.Now().Add(-48 * time.Hour).Truncate(time.Hour).UTC()
+	booking := Booking{UserID: 1, RoomID: room.ID, RoomName: room.Name, StartTime: start, EndTime: start.Add(time.Hour)}
+	require.NoError(t, database.Create(&booking).Error)
+	t.Cleanup(func() {
+		database.Unscoped().Where("room_id IN ?", []uint{room.ID, other.ID}).Delete(&Booking{})
+		database.Unscoped().Delete(&room)
+		database.Unscoped().Delete(&other)
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PATCH("/bookings/:id", requireAuth(), updateBooking)
+	update := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("/bookings/%d", booking.ID), bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusConflict, update(fmt.Sprintf(`{"room_id": %d}`, other.ID)))
+	assert.Equal(t, http.StatusConflict, update(fmt.Sprintf(`{"end_time": %q}`, start.Add(2*time.Hour).Format(time.RFC3339))))
+
+	var stored Booking
+	require.NoError(t, database.First(&stored, booking.ID).Error)
+	assert.Equal(t, room.ID, stored.RoomID)
+	assert.True(t, start.Add(time.Hour).Equal(stored.EndTime))
+}
+
+// TestRoomNameReuse проверяет, что имя удалённой комнаты можно занять снова,
+// а имя действующей, в любом регистре, — нет. Запускается, только если
+// задан TEST_DATABASE_URL.
+func TestRoomNameReuse(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, migrate(database))
+	db = &GormDatabase{Conn: database}
+
+	name := fmt.Sprintf("Reused Room %d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		database.Unscoped().Where("LOWER(name) = LOWER(?)", name).Delete(&Room{})
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/rooms", requireAuth(), requireAdmin(), createRoom)
+	create := func(name string) int {
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(fmt.Sprintf(`{"name": %q}`, name)))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	require.Equal(t, http.StatusCreated, create(name))
+	require.NoError(t, database.Where("name = ?", name).Delete(&Room{}).Error)
+	assert.Equal(t, http.StatusCreated, create(strings.ToLower(name)))
+	assert.Equal(t, http.StatusConflict, create(strings.ToUpper(name)))
+
+	// Индекс ловит дубликат, даже если проверку в коде обошли
+	err = database.Create(&Room{Name: strings.ToUpper(name), Active: true}).Error
+	assert.True(t, isUniqueViolation(err), "%v", err)
+}
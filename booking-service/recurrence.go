@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOccurrences ограничивает размер одной серии, чтобы правило без
+// разумного UNTIL не создало тысячи бронирований.
+const maxOccurrences = 366
+
+// maxPeriods защищает от правил, которые никогда не дают совпадений
+// (например, DAILY;INTERVAL=7;BYDAY=TU при старте в понедельник).
+const maxPeriods = 10000
+
+var errTooManyOccurrences = fmt.Errorf("recurrence produces more than %d occurrences", maxOccurrences)
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// weekdayNum — элемент BYDAY: день недели с необязательным номером
+// вхождения в месяце (1MO — первый понедельник, -1FR — последняя пятница).
+type weekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// recurrence — подмножество RRULE из RFC 5545: FREQ (DAILY, WEEKLY, MONTHLY),
+// INTERVAL, BYDAY, UNTIL и COUNT.
+type recurrence struct {
+	Freq     string
+	Interval int
+	ByDay    []weekdayNum
+	Until    time.Time
+	Count    int
+}
+
+// parseRRule разбирает строку вида "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10".
+// Префикс "RRULE:" допускается. Одно из COUNT или UNTIL обязательно.
+func parseRRule(s string) (*recurrence, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty recurrence rule")
+	}
+
+	r := &recurrence{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate rule part %s", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY":
+				r.Freq = value
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %s", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %s", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %s", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseUntil(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %s", value)
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(code)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, errors.New("FREQ is required")
+	}
+	if r.Count != 0 && !r.Until.IsZero() {
+		return nil, errors.New("COUNT and UNTIL must not both be set")
+	}
+	if r.Count == 0 && r.Until.IsZero() {
+		return nil, errors.New("either COUNT or UNTIL is required")
+	}
+	if r.Freq != "MONTHLY" {
+		for _, wd := range r.ByDay {
+			if wd.N != 0 {
+				return nil, fmt.Errorf("numbered BYDAY is only supported with FREQ=MONTHLY")
+			}
+		}
+	}
+	return r, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	// Дата без времени включает весь день
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+func parseWeekdayNum(code string) (weekdayNum, error) {
+	code = strings.TrimSpace(code)
+	if len(code) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", code)
+	}
+	day, ok := weekdayCodes[code[len(code)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", code)
+	}
+	wd := weekdayNum{Day: day}
+	if prefix := code[:len(code)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", code)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+// expand возвращает начала вхождений серии, начиная с dtstart. Время суток
+// берётся из dtstart в его часовом поясе.
+func (r *recurrence) expand(dtstart time.Time) ([]time.Time, error) {
+	var out []time.Time
+	// add возвращает false, когда серия закончилась
+	add := func(t time.Time) (bool, error) {
+		if t.Before(dtstart) {
+			return true, nil
+		}
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false, nil
+		}
+		if len(out) == maxOccurrences {
+			return false, errTooManyOccurrences
+		}
+		out = append(out, t)
+		return r.Count == 0 || len(out) < r.Count, nil
+	}
+
+	for period := 0; period < maxPeriods; period++ {
+		var candidates []time.Time
+		switch r.Freq {
+		case "DAILY":
+			candidates = r.dailyCandidates(dtstart, period)
+		case "WEEKLY":
+			candidates = r.weeklyCandidates(dtstart, period)
+		case "MONTHLY":
+			candidates = r.monthlyCandidates(dtstart, period)
+		}
+		for _, t := range candidates {
+			more, err := add(t)
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				return out, nil
+			}
+		}
+	}
+	return out, nil
+}
+
+func (r *recurrence) matchesDay(d time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Day == d {
+			return true
+		}
+	}
+	return false
+}
+
+func atClock(dtstart time.Time, year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), dtstart.Nanosecond(), dtstart.Location())
+}
+
+func (r *recurrence) dailyCandidates(dtstart time.Time, period int) []time.Time {
+	t := atClock(dtstart, dtstart.Year(), dtstart.Month(), dtstart.Day()+period*r.Interval)
+	if !r.matchesDay(t.Weekday()) {
+		return nil
+	}
+	return []time.Time{t}
+}
+
+// weeklyCandidates перебирает дни недели, начиная с понедельника (WKST=MO).
+func (r *recurrence) weeklyCandidates(dtstart time.Time, period int) []time.Time {
+	offset := (int(dtstart.Weekday()) + 6) % 7
+	monday := dtstart.Day() - offset + period*r.Interval*7
+
+	var out []time.Time
+	for i := 0; i < 7; i++ {
+		t := atClock(dtstart, dtstart.Year(), dtstart.Month(), monday+i)
+		if len(r.ByDay) == 0 {
+			if t.Weekday() == dtstart.Weekday() {
+				out = append(out, t)
+			}
+		} else if r.matchesDay(t.Weekday()) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// monthlyCandidates без BYDAY повторяет число месяца из dtstart, пропуская
+// месяцы, где такого числа нет (как требует RFC 5545).
+func (r *recurrence) monthlyCandidates(dtstart time.Time, period int) []time.Time {
+	first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(period*r.Interval), 1, 0, 0, 0, 0, dtstart.Location())
+	year, month := first.Year(), first.Month()
+	daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, dtstart.Location()).Day()
+
+	if len(r.ByDay) == 0 {
+		if dtstart.Day() > daysInMonth {
+			return nil
+		}
+		return []time.Time{atClock(dtstart, year, month, dtstart.Day())}
+	}
+
+	days := map[int]bool{}
+	for _, wd := range r.ByDay {
+		var matching []int
+		for d := 1; d <= daysInMonth; d++ {
+			if time.Date(year, month, d, 0, 0, 0, 0, dtstart.Location()).Weekday() == wd.Day {
+				matching = append(matching, d)
+			}
+		}
+		switch {
+		case wd.N == 0:
+			for _, d := range matching {
+				days[d] = true
+			}
+		case wd.N > 0 && wd.N <= len(matching):
+			days[matching[wd.N-1]] = true
+		case wd.N < 0 && -wd.N <= len(matching):
+			days[matching[len(matching)+wd.N]] = true
+		}
+	}
+
+	sorted := make([]int, 0, len(days))
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Ints(sorted)
+
+	out := make([]time.Time, 0, len(sorted))
+	for _, d := range sorted {
+		out = append(out, atClock(dtstart, year, month, d))
+	}
+	return out
+}
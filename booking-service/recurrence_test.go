@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dates(times []time.Time) []string {
+	out := make([]string, len(times))
+	for i, t := range times {
+		out[i] = t.Format("2006-01-02 Mon 15:04")
+	}
+	return out
+}
+
+func TestParseRRule(t *testing.T) {
+	r, err := parseRRule("RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=4")
+	assert.NoError(t, err)
+	assert.Equal(t, "WEEKLY", r.Freq)
+	assert.Equal(t, 2, r.Interval)
+	assert.Equal(t, []weekdayNum{{Day: time.Monday}, {Day: time.Wednesday}}, r.ByDay)
+	assert.Equal(t, 4, r.Count)
+
+	invalid := []string{
+		"",
+		"FREQ=YEARLY;COUNT=2",
+		"FREQ=DAILY",
+		"FREQ=DAILY;COUNT=2;UNTIL=20300101",
+		"FREQ=DAILY;INTERVAL=0;COUNT=2",
+		"FREQ=WEEKLY;BYDAY=1MO;COUNT=2",
+		"FREQ=MONTHLY;BYDAY=XX;COUNT=2",
+		"FREQ=DAILY;BYHOUR=9;COUNT=2",
+	}
+	for _, rule := range invalid {
+		_, err := parseRRule(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestExpandRecurrence(t *testing.T) {
+	// Среда, 2 января 2030
+	dtstart := time.Date(2030, 1, 2, 9, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		rule string
+		want []string
+	}{
+		{"FREQ=DAILY;COUNT=3", []string{"2030-01-02 Wed 09:30", "2030-01-03 Thu 09:30", "2030-01-04 Fri 09:30"}},
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=4", []string{"2030-01-02 Wed 09:30", "2030-01-03 Thu 09:30", "2030-01-04 Fri 09:30", "2030-01-07 Mon 09:30"}},
+		{"FREQ=WEEKLY;UNTIL=20300116", []string{"2030-01-02 Wed 09:30", "2030-01-09 Wed 09:30", "2030-01-16 Wed 09:30"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=4", []string{"2030-01-04 Fri 09:30", "2030-01-14 Mon 09:30", "2030-01-18 Fri 09:30", "2030-01-28 Mon 09:30"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=2", []string{"2030-01-25 Fri 09:30", "2030-02-22 Fri 09:30"}},
+		{"FREQ=MONTHLY;BYDAY=1MO;COUNT=2", []string{"2030-01-07 Mon 09:30", "2030-02-04 Mon 09:30"}},
+	}
+	for _, tc := range cases {
+		r, err := parseRRule(tc.rule)
+		assert.NoError(t, err, tc.rule)
+		got, err := r.expand(dtstart)
+		assert.NoError(t, err, tc.rule)
+		assert.Equal(t, tc.want, dates(got), tc.rule)
+	}
+}
+
+func TestExpandMonthlySkipsMissingDays(t *testing.T) {
+	r, _ := parseRRule("FREQ=MONTHLY;COUNT=3")
+	got, err := r.expand(time.Date(2030, 1, 31, 10, 0, 0, 0, time.UTC))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2030-01-31 Thu 10:00", "2030-03-31 Sun 10:00", "2030-05-31 Fri 10:00"}, dates(got))
+}
+
+func TestExpandTooManyOccurrences(t *testing.T) {
+	r, _ := parseRRule("FREQ=DAILY;UNTIL=20400101")
+	_, err := r.expand(time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC))
+
+	assert.ErrorIs(t, err, errTooManyOccurrences)
+}
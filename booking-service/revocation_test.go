@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"booking/shared/revocation"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func tokenWithJTI(jti string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{UserID: 1, StandardClaims: jwt.StandardClaims{Id: jti}})
+	token.Header["kid"] = "test"
+	tokenString, _ := token.SignedString(testKey)
+	return tokenString
+}
+
+func TestValidateTokenRejectsRevoked(t *testing.T) {
+	saved := revoked
+	defer func() { revoked = saved }()
+	revoked = revocation.New("", "")
+
+	_, err := validateToken(tokenWithJTI("j1"))
+	assert.NoError(t, err)
+
+	revoked.Add("j1", time.Now().Add(time.Hour))
+	_, err = validateToken(tokenWithJTI("j1"))
+	assert.ErrorIs(t, err, errTokenRevoked)
+
+	_, err = validateToken(tokenWithJTI("j2"))
+	assert.NoError(t, err)
+}
+
+func TestPushRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	savedList, savedKey := revoked, internalAPIKey
+	defer func() { revoked, internalAPIKey = savedList, savedKey }()
+	revoked = revocation.New("", "")
+	internalAPIKey = "key"
+
+	r := gin.Default()
+	r.POST("/internal/revocations", requireInternalKey(), pushRevocation)
+
+	push := func(auth string) int {
+		body := `{"jti": "j1", "expires_at": "2030-01-01T12:00:00Z"}`
+		req, _ := http.NewRequest(http.MethodPost, "/internal/revocations", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", auth)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, push("Bearer wrong"))
+	assert.False(t, revoked.IsRevoked("j1"))
+
+	assert.Equal(t, http.StatusOK, push("Bearer key"))
+	assert.True(t, revoked.IsRevoked("j1"))
+}
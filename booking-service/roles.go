@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Роли выдаёт auth-service, в токене они приходят полем role. Токены,
+// выпущенные до появления ролей, считаются токенами обычного участника.
+const (
+	roleAdmin       = "admin"
+	roleMember      = "member"
+	roleRoomManager = "room-manager"
+)
+
+// RoomManager назначает пользователя управляющим комнатой. Управляющий
+// может менять комнату и видеть и отменять любые её бронирования.
+type RoomManager struct {
+	RoomID uint `json:"room_id" gorm:"primaryKey"`
+	UserID uint `json:"user_id" gorm:"primaryKey;index"`
+}
+
+func (c *Claims) isAdmin() bool {
+	return c.Role == roleAdmin
+}
+
+// managesRoom сообщает, может ли пользователь администрировать комнату.
+func managesRoom(claims *Claims, roomID uint) (bool, error) {
+	if claims.isService() {
+		return false, nil
+	}
+	if claims.isAdmin() {
+		return true, nil
+	}
+	if claims.Role != roleRoomManager {
+		return false, nil
+	}
+	err := db.Where("room_id = ? AND user_id = ?", roomID, claims.UserID).First(&RoomManager{})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// canManage сообщает, может ли пользователь смотреть и отменять чужие
+// бронирования комнаты: свои он может всегда.
+func canManage(claims *Claims, ownerID, roomID uint) (bool, error) {
+	if claims.isService() {
+		return false, nil
+	}
+	if ownerID == claims.UserID {
+		return true, nil
+	}
+	return managesRoom(claims, roomID)
+}
+
+// visibleBookings ограничивает выборку бронированиями, которые пользователю
+// разрешено видеть.
+func visibleBookings(q Database, claims *Claims) Database {
+	// Ключу с bookings:read, например табло у переговорной, видны все брони
+	if claims.isService() {
+		return q
+	}
+	switch claims.Role {
+	case roleAdmin:
+		return q
+	case roleRoomManager:
+		return q.Where("user_id = ? OR room_id IN (SELECT room_id FROM room_managers WHERE user_id = ?)",
+			claims.UserID, claims.UserID)
+	default:
+		return q.Where("user_id = ?", claims.UserID)
+	}
+}
+
+// requireAdmin пропускает дальше только администраторов. Ставится после requireAuth.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentClaims(c).isAdmin() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// managerFromParams разбирает :id комнаты и :user_id управляющего.
+func managerFromParams(c *gin.Context) (*RoomManager, bool) {
+	room, ok := roomFromParam(c)
+	if !ok {
+		return nil, false
+	}
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+	return &RoomManager{RoomID: room.ID, UserID: uint(userID)}, true
+}
+
+// getRoomManagers — GET /rooms/:id/managers.
+func getRoomManagers(c *gin.Context) {
+	room, ok := roomFromParam(c)
+	if !ok {
+		return
+	}
+
+	var managers []RoomManager
+	if err := db.Where("room_id = ?", room.ID).Find(&managers); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find room managers error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"managers": managers})
+}
+
+// addRoomManager — PUT /rooms/:id/managers/:user_id. Повторное назначение
+// ничего не меняет. Чтобы назначение действовало, пользователю нужна
+// ещё и роль room-manager в auth-service.
+func addRoomManager(c *gin.Context) {
+	manager, ok := managerFromParams(c)
+	if !ok {
+		return
+	}
+
+	err := db.Where("room_id = ? AND user_id = ?", manager.RoomID, manager.UserID).First(&RoomManager{})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Create(manager)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Add room manager error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"manager": manager})
+}
+
+// removeRoomManager — DELETE /rooms/:id/managers/:user_id.
+func removeRoomManager(c *gin.Context) {
+	manager, ok := managerFromParams(c)
+	if !ok {
+		return
+	}
+
+	if err := db.Delete(&RoomManager{}, "room_id = ? AND user_id = ?", manager.RoomID, manager.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Remove room manager error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Room manager removed"})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestRoomManagers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.PUT("/rooms/:id/managers/:user_id", requireAuth(), requireAdmin(), addRoomManager)
+	r.DELETE("/rooms/:id/managers/:user_id", requireAuth(), requireAdmin(), removeRoomManager)
+
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("admin assigns manager", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Where", "room_id = ? AND user_id = ?", []interface{}{uint(1), uint(5)}).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.RoomManager"), mock.Anything).Return(gorm.ErrRecordNotFound)
+		mockDB.On("Create", &RoomManager{RoomID: 1, UserID: 5}).Return(nil)
+
+		resp := send(http.MethodPut, "/rooms/1/managers/5", signedTokenWithRole(1, roleAdmin))
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"manager": {"room_id": 1, "user_id": 5}}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("repeated assignment is a no-op", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Where", "room_id = ? AND user_id = ?", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.RoomManager"), mock.Anything).Return(nil)
+
+		resp := send(http.MethodPut, "/rooms/1/managers/5", signedTokenWithRole(1, roleAdmin))
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("admin removes manager", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Delete", mock.AnythingOfType("*main.RoomManager"), []interface{}{"room_id = ? AND user_id = ?", uint(1), uint(5)}).Return(nil)
+
+		resp := send(http.MethodDelete, "/rooms/1/managers/5", signedTokenWithRole(1, roleAdmin))
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("room manager cannot assign managers", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := send(http.MethodPut, "/rooms/1/managers/6", signedTokenWithRole(5, roleRoomManager))
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "Create", mock.Anything)
+	})
+}
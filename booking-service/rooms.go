@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errRoomNotFound = errors.New("unknown room")
+	errRoomInactive = errors.New("room is inactive")
+)
+
+// Room — переговорная. Имя уникально без учёта регистра среди неудалённых
+// комнат (индекс roomNameIndex): имя удалённой комнаты можно занять снова.
+type Room struct {
+	gorm.Model
+	Name      string   `json:"name"`
+	Capacity  int      `json:"capacity"`
+	Location  string   `json:"location"`
+	Amenities []string `json:"amenities" gorm:"serializer:json;type:text"`
+	Active    bool     `json:"active"`
+}
+
+// roomInput — тело запросов создания и изменения комнаты. Указатели
+// позволяют отличить отсутствующее поле от нулевого значения.
+type roomInput struct {
+	Name      *string   `json:"name"`
+	Capacity  *int      `json:"capacity"`
+	Location  *string   `json:"location"`
+	Amenities *[]string `json:"amenities"`
+	Active    *bool     `json:"active"`
+}
+
+// normalizeRoomName убирает лишние пробелы, чтобы "Conference  Room " и
+// "Conference Room" считались одной комнатой.
+func normalizeRoomName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func findRoomByName(name string) (*Room, error) {
+	var room Room
+	if err := db.Where("LOWER(name) = LOWER(?)", normalizeRoomName(name)).First(&room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// resolveRoom находит активную комнату по ID, а если ID не задан — по имени.
+func resolveRoom(roomID uint, roomName string) (*Room, error) {
+	var room *Room
+	var err error
+	if roomID != 0 {
+		room = &Room{}
+		err = db.First(room, roomID)
+	} else {
+		room, err = findRoomByName(roomName)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errRoomNotFound
+		}
+		return nil, err
+	}
+	if !room.Active {
+		return nil, errRoomInactive
+	}
+	return room, nil
+}
+
+func (in *roomInput) apply(room *Room) {
+	if in.Name != nil {
+		room.Name = normalizeRoomName(*in.Name)
+	}
+	if in.Capacity != nil {
+		room.Capacity = *in.Capacity
+	}
+	if in.Location != nil {
+		room.Location = strings.TrimSpace(*in.Location)
+	}
+	if in.Amenities != nil {
+		room.Amenities = *in.Amenities
+	}
+	if in.Active != nil {
+		room.Active = *in.Active
+	}
+}
+
+func validateRoom(room *Room) string {
+	if room.Name == "" {
+		return "Room name is required"
+	}
+	if room.Capacity < 0 {
+		return "Capacity must not be negative"
+	}
+	return ""
+}
+
+// roomFromParam загружает комнату по :id, при ошибке отвечает сам.
+func roomFromParam(c *gin.Context) (*Room, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room ID"})
+		return nil, false
+	}
+
+	var room Room
+	if err := db.First(&room, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find room error: %v", err)
+		}
+		return nil, false
+	}
+	return &room, true
+}
+
+// roomNameTaken проверяет, что имя не занято другой комнатой.
+func roomNameTaken(name string, exceptID uint) (bool, error) {
+	existing, err := findRoomByName(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return existing.ID != exceptID, nil
+}
+
+func createRoom(c *gin.Context) {
+	var input roomInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	room := Room{Active: true}
+	input.apply(&room)
+	if msg := validateRoom(&room); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	taken, err := roomNameTaken(room.Name, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find room error: %v", err)
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Room already exists"})
+		return
+	}
+
+	// Параллельный запрос мог занять имя уже после проверки
+	if err := db.Create(&room); err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Room already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Create room error: %v", err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"room": room})
+}
+
+func getRooms(c *gin.Context) {
+	var rooms []Room
+	var err error
+	if c.Query("active") == "true" {
+		err = db.Where("active = ?", true).Find(&rooms)
+	} else {
+		err = db.Find(&rooms)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve rooms"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rooms": rooms})
+}
+
+func getRoom(c *gin.Context) {
+	room, ok := roomFromParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room})
+}
+
+// updateRoom доступен администратору и управляющим этой комнатой.
+func updateRoom(c *gin.Context) {
+	room, ok := roomFromParam(c)
+	if !ok {
+		return
+	}
+
+	allowed, err := managesRoom(currentClaims(c), room.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check room manager error: %v", err)
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	var input roomInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.apply(room)
+	if msg := validateRoom(room); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	taken, err := roomNameTaken(room.Name, room.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Find room error: %v", err)
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Room already exists"})
+		return
+	}
+
+	if err := db.Save(room); err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Room already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Update room error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room})
+}
+
+func deleteRoom(c *gin.Context) {
+	room, ok := roomFromParam(c)
+	if !ok {
+		return
+	}
+
+	if err := db.Delete(room); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Delete room error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Room deleted successfully"})
+}
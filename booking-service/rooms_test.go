@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestCreateRoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/rooms", requireAuth(), requireAdmin(), createRoom)
+
+	t.Run("successfully create room", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "LOWER(name) = LOWER(?)", []interface{}{"Conference Room"}).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.Room"), mock.Anything).Return(gorm.ErrRecordNotFound)
+		mockDB.On("Create", mock.MatchedBy(func(room *Room) bool {
+			return room.Name == "Conference Room" && room.Capacity == 8 && room.Active
+		})).Return(nil)
+
+		body := `{"name": " Conference   Room ", "capacity": 8, "location": "2nd floor", "amenities": ["projector"]}`
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		var response struct {
+			Room Room `json:"room"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		assert.Equal(t, []string{"projector"}, response.Room.Amenities)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "LOWER(name) = LOWER(?)", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.Room"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			args.Get(0).(*Room).ID = 3
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(`{"name": "conference room"}`))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.JSONEq(t, `{"error": "Room already exists"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("name taken by a concurrent request", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("Where", "LOWER(name) = LOWER(?)", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.Room"), mock.Anything).Return(gorm.ErrRecordNotFound)
+		mockDB.On("Create", mock.AnythingOfType("*main.Room")).Return(&pgconn.PgError{Code: uniqueViolation})
+
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(`{"name": "Conference Room"}`))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.JSONEq(t, `{"error": "Room already exists"}`, resp.Body.String())
+	})
+
+	t.Run("missing name", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(`{"name": "   ", "capacity": 4}`))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "Room name is required"}`, resp.Body.String())
+	})
+
+	t.Run("member is forbidden", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(`{"name": "Room1"}`))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("unauthorized request", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/rooms", bytes.NewBufferString(`{"name": "Room1"}`))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func TestGetRoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/rooms/:id", requireAuth(), getRoom)
+
+	t.Run("room not found", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockDB.On("First", mock.AnythingOfType("*main.Room"), []interface{}{uint64(9)}).Return(gorm.ErrRecordNotFound)
+
+		req, _ := http.NewRequest(http.MethodGet, "/rooms/9", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		assert.JSONEq(t, `{"error": "Room not found"}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestUpdateRoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.PATCH("/rooms/:id", requireAuth(), updateRoom)
+
+	t.Run("deactivate room", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Where", "LOWER(name) = LOWER(?)", mock.Anything).Return(mockDB)
+		mockDB.On("Save", mock.MatchedBy(func(room *Room) bool {
+			return room.ID == 1 && !room.Active
+		})).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodPatch, "/rooms/1", bytes.NewBufferString(`{"active": false}`))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(1, roleAdmin))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("room manager updates their room", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Where", "room_id = ? AND user_id = ?", []interface{}{uint(1), uint(5)}).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*main.RoomManager"), mock.Anything).Return(nil)
+		mockDB.On("Where", "LOWER(name) = LOWER(?)", mock.Anything).Return(mockDB)
+		mockDB.On("Save", mock.MatchedBy(func(room *Room) bool {
+			return room.ID == 1 && room.Capacity == 12
+		})).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodPatch, "/rooms/1", bytes.NewBufferString(`{"capacity": 12}`))
+		req.Header.Set("Authorization", "Bearer "+signedTokenWithRole(5, roleRoomManager))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("member is forbidden", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+
+		req, _ := http.NewRequest(http.MethodPatch, "/rooms/1", bytes.NewBufferString(`{"active": false}`))
+		req.Header.Set("Authorization", "Bearer "+signedToken(5))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		mockDB.AssertNotCalled(t, "Save", mock.Anything)
+	})
+}
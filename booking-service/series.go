@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BookingSeries — повторяющееся бронирование. Каждое вхождение хранится
+// отдельной записью Booking со ссылкой SeriesID, поэтому его можно отменить
+// или перенести обычными DELETE/PATCH /bookings/:id, не ломая серию.
+type BookingSeries struct {
+	gorm.Model
+	RoomID    uint      `json:"room_id" gorm:"index"`
+	RoomName  string    `json:"room_name"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	RRule     string    `json:"rrule"`
+}
+
+type seriesRequest struct {
+	RoomID    uint      `json:"room_id"`
+	RoomName  string    `json:"room_name"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	RRule     string    `json:"rrule" binding:"required"`
+}
+
+// occurrences раскладывает серию на бронирования длительностью первого вхождения.
+func (s *BookingSeries) occurrences(starts []time.Time) []Booking {
+	duration := s.EndTime.Sub(s.StartTime)
+	bookings := make([]Booking, 0, len(starts))
+	for _, start := range starts {
+		bookings = append(bookings, Booking{
+			RoomID:    s.RoomID,
+			RoomName:  s.RoomName,
+			StartTime: start,
+			EndTime:   start.Add(duration),
+			UserID:    s.UserID,
+		})
+	}
+	return bookings
+}
+
+// createSeries раскладывает правило на вхождения и создаёт их в одной
+// транзакции: если хотя бы одно пересекается с чужой бронью, не создаётся ничего.
+func createSeries(c *gin.Context) {
+	claims := currentClaims(c)
+
+	var req seriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Все вхождения повторяют длительность и время суток первого,
+	// поэтому достаточно проверить его
+	if errs := rules.validate(req.StartTime, req.EndTime, true); len(errs) > 0 {
+		respondValidation(c, errs)
+		return
+	}
+
+	rule, err := parseRRule(req.RRule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	starts, err := rule.expand(req.StartTime)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(starts) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "recurrence produces no occurrences"})
+		return
+	}
+
+	var template Booking
+	if !assignRoom(c, &template, req.RoomID, req.RoomName) {
+		return
+	}
+
+	series := BookingSeries{
+		RoomID:    template.RoomID,
+		RoomName:  template.RoomName,
+		UserID:    claims.UserID,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		RRule:     req.RRule,
+	}
+	bookings := series.occurrences(starts)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&series).Error; err != nil {
+			return err
+		}
+		for i := range bookings {
+			bookings[i].SeriesID = &series.ID
+			if err := checkOverlap(tx, &bookings[i]); err != nil {
+				return err
+			}
+			if err := tx.Create(&bookings[i]).Error; err != nil {
+				return bookingWriteError(err, &bookings[i])
+			}
+			if err := recordEvent(tx, eventBookingCreated, &bookings[i], claims.UserID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, errRoomBooked) {
+			// В ответе "requested" указывает, какое из вхождений не поместилось
+			respondConflict(c, claims, err, &bookings[0])
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Transaction error: %v", err)
+		}
+		return
+	}
+	wakeRelay()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recurring booking created successfully", "series": series, "bookings": bookings})
+}
+
+// seriesFromParam загружает серию по :id и проверяет, что она принадлежит
+// пользователю или он управляет её комнатой.
+func seriesFromParam(c *gin.Context, claims *Claims) (*BookingSeries, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid series ID"})
+		return nil, false
+	}
+
+	var series BookingSeries
+	if err := db.First(&series, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find series error: %v", err)
+		}
+		return nil, false
+	}
+	allowed, err := canManage(claims, series.UserID, series.RoomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Check room manager error: %v", err)
+		return nil, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return nil, false
+	}
+	return &series, true
+}
+
+func getSeries(c *gin.Context) {
+	claims := currentClaims(c)
+	series, ok := seriesFromParam(c, claims)
+	if !ok {
+		return
+	}
+
+	var bookings []Booking
+	if err := db.Where("series_id = ?", series.ID).Order("start_time ASC").Find(&bookings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve bookings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"series": series, "bookings": bookings})
+}
+
+// deleteSeries отменяет ещё не начавшиеся вхождения серии. Прошедшие
+// остаются в истории.
+func deleteSeries(c *gin.Context) {
+	claims := currentClaims(c)
+	series, ok := seriesFromParam(c, claims)
+	if !ok {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := cancelBookings(tx, claims.UserID, "series_id = ? AND start_time > ?", series.ID, now()); err != nil {
+			return err
+		}
+		return tx.Delete(series).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Transaction error: %v", err)
+		return
+	}
+	wakeRelay()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Series cancelled successfully"})
+}
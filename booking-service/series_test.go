@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateSeries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/series", requireAuth(), createSeries)
+
+	body := `{"room_id": 1, "start_time": "2030-01-07T09:00:00Z", "end_time": "2030-01-07T09:15:00Z", "rrule": "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=10"}`
+
+	t.Run("successfully create series", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Transaction", mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodPost, "/series", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response struct {
+			Bookings []Booking `json:"bookings"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &response)
+		assert.Len(t, response.Bookings, 10)
+		assert.Equal(t, "Room1", response.Bookings[9].RoomName)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("conflicting occurrence", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		mockActiveRoom(mockDB, true)
+		mockDB.On("Transaction", mock.Anything).Return(errRoomBooked)
+		mockConflictLookups(mockDB)
+
+		req, _ := http.NewRequest(http.MethodPost, "/series", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("invalid rule", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/series", bytes.NewBufferString(
+			`{"room_id": 1, "start_time": "2030-01-07T09:00:00Z", "end_time": "2030-01-07T09:15:00Z", "rrule": "FREQ=DAILY"}`))
+		req.Header.Set("Authorization", "Bearer "+signedToken(1))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error": "either COUNT or UNTIL is required"}`, resp.Body.String())
+	})
+}
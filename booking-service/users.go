@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// cancelUserBookings — POST /internal/users/:id/cancel-bookings. auth-service
+// вызывает его перед удалением учётной записи. Отменяются ещё не начавшиеся
+// бронирования, прошедшие остаются в истории; заодно удаляются серии,
+// ссылка на календарь и назначения управляющим. Повторный вызов безопасен.
+func cancelUserBookings(c *gin.Context) {
+	userID, err := parseID(c.Param("id"))
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var cancelled int
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Отмену инициирует сам пользователь, удаляя учётную запись
+		n, err := cancelBookings(tx, userID, "user_id = ? AND start_time > ?", userID, now())
+		if err != nil {
+			return err
+		}
+		cancelled = n
+		for _, model := range []interface{}{&BookingSeries{}, &CalendarFeed{}, &RoomManager{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Cancel user bookings error: %v", err)
+		return
+	}
+	wakeRelay()
+
+	log.Printf("Cancelled %d future bookings of deleted user %d", cancelled, userID)
+	c.JSON(http.StatusOK, gin.H{"cancelled": cancelled})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCancelUserBookings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	savedKey := internalAPIKey
+	defer func() { internalAPIKey = savedKey }()
+	internalAPIKey = "key"
+
+	r := gin.Default()
+	r.POST("/internal/users/:id/cancel-bookings", requireInternalKey(), cancelUserBookings)
+
+	send := func(path, auth string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", auth)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("cancels in a transaction", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("Transaction", mock.Anything).Return(nil)
+
+		resp := send("/internal/users/7/cancel-bookings", "Bearer key")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"cancelled": 0}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("user bearer token is not enough", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := send("/internal/users/7/cancel-bookings", "Bearer "+signedToken(7))
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+	})
+
+	t.Run("invalid user ID", func(t *testing.T) {
+		db = new(MockDatabase)
+
+		resp := send("/internal/users/abc/cancel-bookings", "Bearer key")
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
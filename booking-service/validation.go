@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bookingRules — ограничения на интервалы бронирования. Значения по умолчанию
+// переопределяются переменными BOOKING_MAX_DURATION и BOOKING_GRANULARITY.
+type bookingRules struct {
+	MaxDuration time.Duration
+	Granularity time.Duration
+}
+
+var rules = bookingRules{
+	MaxDuration: 12 * time.Hour,
+	Granularity: 15 * time.Minute,
+}
+
+// now подменяется в тестах
+var now = time.Now
+
+func loadBookingRules() {
+	if v := os.Getenv("BOOKING_MAX_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid BOOKING_MAX_DURATION: %q", v)
+		}
+		rules.MaxDuration = d
+	}
+	if v := os.Getenv("BOOKING_GRANULARITY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid BOOKING_GRANULARITY: %q", v)
+		}
+		rules.Granularity = d
+	}
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrors возвращается и как error из транзакций, поэтому
+// реализует интерфейс error.
+type validationErrors []fieldError
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validate проверяет интервал [start, end). checkPast отключают, когда
+// начало не меняется, чтобы можно было продлить уже идущую бронь.
+func (r bookingRules) validate(start, end time.Time, checkPast bool) validationErrors {
+	var errs validationErrors
+	if start.IsZero() {
+		errs = append(errs, fieldError{"start_time", "is required"})
+	}
+	if end.IsZero() {
+		errs = append(errs, fieldError{"end_time", "is required"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if !end.After(start) {
+		errs = append(errs, fieldError{"end_time", "must be after start_time"})
+	} else if r.MaxDuration > 0 && end.Sub(start) > r.MaxDuration {
+		errs = append(errs, fieldError{"end_time", fmt.Sprintf("booking must not be longer than %s", r.MaxDuration)})
+	}
+	if checkPast && start.Before(now()) {
+		errs = append(errs, fieldError{"start_time", "must not be in the past"})
+	}
+	if r.Granularity > 0 {
+		if !start.Truncate(r.Granularity).Equal(start) {
+			errs = append(errs, fieldError{"start_time", fmt.Sprintf("must be aligned to %s", r.Granularity)})
+		}
+		if !end.Truncate(r.Granularity).Equal(end) {
+			errs = append(errs, fieldError{"end_time", fmt.Sprintf("must be aligned to %s", r.Granularity)})
+		}
+	}
+	return errs
+}
+
+func respondValidation(c *gin.Context, errs validationErrors) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking time", "fields": errs})
+}
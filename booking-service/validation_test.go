@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBookingTimes(t *testing.T) {
+	fixed := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	r := bookingRules{MaxDuration: 8 * time.Hour, Granularity: 15 * time.Minute}
+	at := func(d time.Duration) time.Time { return fixed.Add(d) }
+
+	cases := []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		checkPast bool
+		want      validationErrors
+	}{
+		{"valid", at(time.Hour), at(2 * time.Hour), true, nil},
+		{"missing times", time.Time{}, time.Time{}, true, validationErrors{{"start_time", "is required"}, {"end_time", "is required"}}},
+		{"inverted", at(2 * time.Hour), at(time.Hour), true, validationErrors{{"end_time", "must be after start_time"}}},
+		{"empty", at(time.Hour), at(time.Hour), true, validationErrors{{"end_time", "must be after start_time"}}},
+		{"too long", at(time.Hour), at(10 * time.Hour), true, validationErrors{{"end_time", "booking must not be longer than 8h0m0s"}}},
+		{"in the past", at(-time.Hour), at(time.Hour), true, validationErrors{{"start_time", "must not be in the past"}}},
+		{"past start allowed", at(-time.Hour), at(time.Hour), false, nil},
+		{"misaligned", at(time.Hour + 5*time.Minute), at(2*time.Hour + time.Second), true, validationErrors{
+			{"start_time", "must be aligned to 15m0s"},
+			{"end_time", "must be aligned to 15m0s"},
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, r.validate(tc.start, tc.end, tc.checkPast))
+		})
+	}
+}
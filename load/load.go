@@ -76,7 +76,10 @@ func login() (string, error) {
 func loadTestBooking(wg *sync.WaitGroup, token string) {
 	defer wg.Done()
 	headers := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + token}
-	bookingBody := []byte(`{"room_name": "Conference Room", "start_time": "2025-12-10T10:00:00Z", "end_time": "2026-12-10T11:00:00Z"}`)
+	// Все запросы бьются за один и тот же час завтра: успешным должен быть только один
+	start := time.Now().UTC().Truncate(time.Hour).Add(24 * time.Hour)
+	bookingBody := []byte(fmt.Sprintf(`{"room_name": "Conference Room", "start_time": %q, "end_time": %q}`,
+		start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)))
 
 	for i := 0; i < totalRequests/concurrency; i++ {
 		statusCode, err := sendRequest(http.MethodPost, requestURLBooking, bookingBody, headers)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"booking/shared/jwks"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// internalAPIKey — общий с другими сервисами секрет для служебных запросов.
+var internalAPIKey string
+
+func loadInternalKey() {
+	internalAPIKey = os.Getenv("INTERNAL_API_KEY")
+}
+
+// requireInternalKey пропускает служебные запросы других сервисов с общим
+// секретом INTERNAL_API_KEY. Без секрета такие маршруты отключены.
+func requireInternalKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := "Bearer " + internalAPIKey
+		if internalAPIKey == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// jwtKeys — открытые ключи auth-service, по которым проверяются токены
+// пользователей, читающих свои in-app уведомления.
+var jwtKeys *jwks.Cache
+
+func loadJWKS() {
+	url := os.Getenv("JWKS_URL")
+	if url == "" {
+		log.Printf("JWKS_URL is not set, the in-app inbox is disabled")
+		return
+	}
+	jwtKeys = jwks.New(url)
+}
+
+type Claims struct {
+	UserID uint `json:"user_id"`
+	jwt.StandardClaims
+}
+
+const recipientKey = "recipient"
+
+// requireUser проверяет access-токен пользователя, в том числе по списку
+// отзыва, и кладёт в контекст его ID как получателя in-app уведомлений.
+func requireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, tokenStr, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if jwtKeys == nil || !strings.EqualFold(scheme, "Bearer") || tokenStr == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		claims := &Claims{}
+		token, err := jwt.ParseWithClaims(strings.TrimSpace(tokenStr), claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return jwtKeys.KeyFor(token)
+		})
+		if err != nil || !token.Valid || claims.UserID == 0 || (claims.Id != "" && revoked.IsRevoked(claims.Id)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set(recipientKey, strconv.FormatUint(uint64(claims.UserID), 10))
+		c.Next()
+	}
+}
+
+// currentRecipient возвращает получателя, положенного requireUser.
+func currentRecipient(c *gin.Context) string {
+	return c.GetString(recipientKey)
+}
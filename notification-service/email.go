@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// emailNotifier отправляет письма через SMTP-сервер. Получатель — адрес
+// электронной почты.
+type emailNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+	// send подменяется в тестах; по умолчанию smtp.SendMail
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func newEmailNotifier(addr, from, username, password string) *emailNotifier {
+	n := &emailNotifier{addr: addr, from: from, send: smtp.SendMail}
+	// smtp.PlainAuth сам откажется передавать пароль без TLS, кроме localhost
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+func (e *emailNotifier) Validate(recipient string) error {
+	addr, err := mail.ParseAddress(recipient)
+	if err != nil || addr.Address != recipient {
+		return fmt.Errorf("must be an email address")
+	}
+	return nil
+}
+
+func (e *emailNotifier) Send(n *Notification) error {
+	return e.send(e.addr, e.auth, e.from, []string{n.Recipient}, e.message(n))
+}
+
+// message собирает письмо по RFC 5322. Тема кодируется по RFC 2047, текст —
+// base64, чтобы не-ASCII и длинные строки доходили без искажений.
+func (e *emailNotifier) message(n *Notification) []byte {
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", e.from},
+		{"To", n.Recipient},
+		{"Subject", encodeSubject(n.Subject)},
+		{"Date", n.CreatedAt.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<notification-%d@notification-service>", n.ID)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `text/plain; charset="utf-8"`},
+		{"Content-Transfer-Encoding", "base64"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(n.Body))
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	msg.WriteString(encoded + "\r\n")
+	return msg.Bytes()
+}
+
+// encodeSubject кодирует тему по RFC 2047. Закодированное слово бывает
+// длиной до 75 символов, поэтому каждое начинается с новой строки
+// заголовка, иначе строка вместе с "Subject: " выйдет за 78 символов.
+func encodeSubject(subject string) string {
+	encoded := mime.QEncoding.Encode("utf-8", subject)
+	if encoded == subject {
+		return subject
+	}
+	return "\r\n " + strings.ReplaceAll(encoded, "?= =?", "?=\r\n =?")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpMessage — письмо, принятое тестовым SMTP-сервером.
+type smtpMessage struct {
+	from string
+	to   []string
+	auth string
+	data string
+}
+
+// fakeSMTP — минимальный SMTP-сервер на localhost: принимает письма и
+// отдаёт их в канал. Адреса из reject отклоняются на RCPT TO.
+type fakeSMTP struct {
+	addr     string
+	messages chan smtpMessage
+	reject   map[string]bool
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{addr: ln.Addr().String(), messages: make(chan smtpMessage, 10), reject: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost fake ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			msg.auth = string(decoded)
+			tp.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			to := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if s.reject[to] {
+				tp.PrintfLine("550 No such user")
+				continue
+			}
+			msg.to = append(msg.to, to)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			tp.PrintfLine("250 OK")
+			s.messages <- msg
+			msg = smtpMessage{}
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) next(t *testing.T) smtpMessage {
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+		return smtpMessage{}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	t.Run("delivers through SMTP", func(t *testing.T) {
+		server := newFakeSMTP(t)
+		notifier := newEmailNotifier(server.addr, "noreply@example.com", "mailer", "secret")
+		n := &Notification{
+			Recipient: "alice@example.com",
+			Subject:   "Сброс пароля",
+			Body:      "Open https://app/reset?token=t\nwithin 30 minutes. " + strings.Repeat("x", 100),
+		}
+		n.ID = 7
+		n.CreatedAt = time.Now()
+
+		require.NoError(t, notifier.Send(n))
+
+		msg := server.next(t)
+		assert.Equal(t, "noreply@example.com", msg.from)
+		assert.Equal(t, []string{"alice@example.com"}, msg.to)
+		assert.Equal(t, "\x00mailer\x00secret", msg.auth)
+
+		parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(msg.data)))
+		require.NoError(t, err)
+		subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+		require.NoError(t, err)
+		assert.Equal(t, "Сброс пароля", subject)
+		assert.Equal(t, "alice@example.com", parsed.Header.Get("To"))
+		assert.Equal(t, "<notification-7@notification-service>", parsed.Header.Get("Message-ID"))
+		body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, parsed.Body))
+		require.NoError(t, err)
+		assert.Equal(t, n.Body, string(body))
+		for _, line := range strings.Split(msg.data, "\n") {
+			assert.LessOrEqual(t, len(line), 78)
+		}
+	})
+
+	t.Run("rejected recipient is an error", func(t *testing.T) {
+		server := newFakeSMTP(t)
+		server.reject["ghost@example.com"] = true
+		notifier := newEmailNotifier(server.addr, "noreply@example.com", "", "")
+
+		err := notifier.Send(&Notification{Recipient: "ghost@example.com", Subject: "Hi", Body: "x"})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("validates addresses", func(t *testing.T) {
+		notifier := newEmailNotifier("localhost:25", "noreply@example.com", "", "")
+
+		assert.NoError(t, notifier.Validate("alice@example.com"))
+		assert.Error(t, notifier.Validate("alice"))
+		assert.Error(t, notifier.Validate("Alice <alice@example.com>"))
+		assert.Error(t, notifier.Validate("alice@example.com\r\nBcc: eve@example.com"))
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bookingEvent — событие жизненного цикла брони от booking-service.
+type bookingEvent struct {
+	ID         string    `json:"id" binding:"required"`
+	Type       string    `json:"type" binding:"required"`
+	BookingID  uint      `json:"booking_id" binding:"required"`
+	UserID     uint      `json:"user_id" binding:"required"`
+	ActorID    uint      `json:"actor_id"`
+	RoomID     uint      `json:"room_id"`
+	RoomName   string    `json:"room_name"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// eventTemplates — шаблон in-app уведомления владельцу брони для каждого
+// типа события.
+var eventTemplates = map[string]string{
+	"BookingCreated":   "booking_created",
+	"BookingUpdated":   "booking_updated",
+	"BookingCancelled": "booking_cancelled",
+}
+
+const eventTimeFormat = "Mon, 02 Jan 2006 15:04 MST"
+
+// receiveEvent — POST /events. booking-service повторяет отправку, пока
+// не получит 2xx, поэтому повтор уже обработанного события тоже
+// подтверждается, но второго уведомления не создаёт.
+func receiveEvent(c *gin.Context) {
+	var event bookingEvent
+	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	template, ok := eventTemplates[event.Type]
+	if !ok {
+		// Неизвестные типы подтверждаем, иначе отправитель повторял бы их вечно
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	payload := map[string]interface{}{
+		"booking_id": event.BookingID,
+		"room_name":  event.RoomName,
+		"start_time": event.StartTime.UTC().Format(eventTimeFormat),
+		"end_time":   event.EndTime.UTC().Format(eventTimeFormat),
+		"actor_id":   event.ActorID,
+	}
+	n, err := newNotification(strconv.FormatUint(uint64(event.UserID), 10), channelInApp, template, payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	n.EventID = &event.ID
+
+	created, err := db.CreateEventNotification(n)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Create event notification error: %v", err)
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"message": "Event already processed"})
+		return
+	}
+	go dispatch(*n)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Event accepted", "id": n.ID})
+}
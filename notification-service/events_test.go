@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReceiveEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.POST("/events", requireInternalKey(), receiveEvent)
+
+	post := func(body, auth string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/events", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+auth)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+	event := `{"id": "e1", "type": "BookingCancelled", "booking_id": 7, "user_id": 42, "actor_id": 1,
+		"room_id": 3, "room_name": "Room1", "start_time": "2030-01-01T10:00:00Z", "end_time": "2030-01-01T11:00:00Z"}`
+
+	t.Run("creates an in-app notification for the owner", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		created := make(chan *Notification, 1)
+		var n *Notification
+		mockDB.On("CreateEventNotification", mock.Anything).Run(func(args mock.Arguments) {
+			n = args.Get(0).(*Notification)
+			n.ID = 11
+		}).Return(true, nil)
+		// Уведомление приходит в канал, когда фоновая доставка уже взяла его
+		mockDB.On("ClaimNotification", uint(11), mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+			created <- n
+		}).Return(false, nil)
+
+		resp := post(event, "internal")
+
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		n = <-created
+		assert.Equal(t, "42", n.Recipient)
+		assert.Equal(t, channelInApp, n.Channel)
+		assert.Equal(t, "booking_cancelled", n.Template)
+		assert.Equal(t, "Booking cancelled", n.Subject)
+		assert.Equal(t, "Your booking of Room1 from Tue, 01 Jan 2030 10:00 UTC to Tue, 01 Jan 2030 11:00 UTC has been cancelled.", n.Body)
+		if assert.NotNil(t, n.EventID) {
+			assert.Equal(t, "e1", *n.EventID)
+		}
+	})
+
+	t.Run("repeated event is acknowledged once more without a new notification", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("CreateEventNotification", mock.Anything).Return(false, nil)
+
+		resp := post(event, "internal")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"message": "Event already processed"}`, resp.Body.String())
+		mockDB.AssertNotCalled(t, "ClaimNotification", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown event types are acknowledged and ignored", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := post(`{"id": "e2", "type": "RoomRenamed", "booking_id": 7, "user_id": 42}`, "internal")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertNotCalled(t, "CreateEventNotification", mock.Anything)
+	})
+
+	t.Run("malformed event", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := post(`{"type": "BookingCreated", "booking_id": 7}`, "internal")
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertNotCalled(t, "CreateEventNotification", mock.Anything)
+	})
+
+	t.Run("requires the internal key", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		resp := post(event, "wrong")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertNotCalled(t, "CreateEventNotification", mock.Anything)
+	})
+}
@@ -1,27 +1,129 @@
 package main
 
 import (
-	"net/http"
+	"log"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func sendNotification(c *gin.Context) {
-	type Notification struct {
-		Message string `json:"message"`
+var db Database
+
+// Определяем интерфейс для базы данных
+type Database interface {
+	CreateNotification(n *Notification) error
+	// CreateEventNotification сохраняет уведомление о событии, если по
+	// событию с тем же EventID уведомления ещё нет.
+	CreateEventNotification(n *Notification) (bool, error)
+	FindNotification(id uint) (*Notification, error)
+	// ClaimNotification откладывает следующую попытку до leaseUntil, если
+	// уведомление всё ещё ждёт доставки к at. Так одно уведомление не
+	// доставляют одновременно несколько реплик.
+	ClaimNotification(id uint, at, leaseUntil time.Time) (bool, error)
+	DueNotifications(at time.Time, limit int) ([]Notification, error)
+	// RecordAttempt сохраняет попытку и новое состояние уведомления вместе.
+	RecordAttempt(n *Notification, attempt *DeliveryAttempt) error
+	ListAttempts(notificationID uint) ([]DeliveryAttempt, error)
+	ListInbox(recipient string, limit int) ([]Notification, error)
+	// MarkRead отмечает in-app уведомление прочитанным; повторный вызов
+	// время прочтения не меняет.
+	MarkRead(id uint, recipient string, at time.Time) (bool, error)
+}
+
+type GormDatabase struct {
+	Conn *gorm.DB
+}
+
+func initDB() {
+	dsn := os.Getenv("DATABASE_URL")
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("failed to connect to the database: %v", err)
 	}
-	var notification Notification
-	if err := c.ShouldBindJSON(&notification); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	if err := database.AutoMigrate(&Notification{}, &DeliveryAttempt{}); err != nil {
+		log.Fatalf("failed to migrate the database: %v", err)
 	}
-	// Здесь можно добавить логику для отправки уведомления
-	c.JSON(http.StatusOK, gin.H{"message": "Notification sent"})
+	db = &GormDatabase{Conn: database}
+}
+
+func (g *GormDatabase) CreateNotification(n *Notification) error {
+	return g.Conn.Create(n).Error
+}
+
+func (g *GormDatabase) CreateEventNotification(n *Notification) (bool, error) {
+	result := g.Conn.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "event_id"}}, DoNothing: true}).Create(n)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) FindNotification(id uint) (*Notification, error) {
+	var n Notification
+	if err := g.Conn.First(&n, id).Error; err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (g *GormDatabase) ClaimNotification(id uint, at, leaseUntil time.Time) (bool, error) {
+	result := g.Conn.Model(&Notification{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, statusPending, at).
+		Update("next_attempt_at", leaseUntil)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (g *GormDatabase) DueNotifications(at time.Time, limit int) ([]Notification, error) {
+	var due []Notification
+	err := g.Conn.Where("status = ? AND next_attempt_at <= ?", statusPending, at).
+		Order("next_attempt_at").Limit(limit).Find(&due).Error
+	return due, err
+}
+
+func (g *GormDatabase) RecordAttempt(n *Notification, attempt *DeliveryAttempt) error {
+	return g.Conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(n).Updates(map[string]interface{}{
+			"status":          n.Status,
+			"attempts":        n.Attempts,
+			"next_attempt_at": n.NextAttemptAt,
+			"sent_at":         n.SentAt,
+		}).Error
+	})
+}
+
+func (g *GormDatabase) ListAttempts(notificationID uint) ([]DeliveryAttempt, error) {
+	var attempts []DeliveryAttempt
+	err := g.Conn.Where("notification_id = ?", notificationID).Order("id").Find(&attempts).Error
+	return attempts, err
+}
+
+func (g *GormDatabase) ListInbox(recipient string, limit int) ([]Notification, error) {
+	var inbox []Notification
+	err := g.Conn.Where("channel = ? AND recipient = ? AND status = ?", channelInApp, recipient, statusSent).
+		Order("id DESC").Limit(limit).Find(&inbox).Error
+	return inbox, err
+}
+
+func (g *GormDatabase) MarkRead(id uint, recipient string, at time.Time) (bool, error) {
+	result := g.Conn.Model(&Notification{}).
+		Where("id = ? AND channel = ? AND recipient = ?", id, channelInApp, recipient).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", at))
+	return result.RowsAffected == 1, result.Error
 }
 
 func main() {
+	loadInternalKey()
+	loadRevocationConfig()
+	loadNotifiers()
+	loadJWKS()
+	initDB()
+	go runDispatcher(dispatchInterval)
+
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
@@ -31,6 +133,13 @@ func main() {
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
-	r.POST("/notify", sendNotification)
+	// Отправлять уведомления могут только другие сервисы
+	r.POST("/notify", requireInternalKey(), sendNotification)
+	r.GET("/notifications/:id", requireInternalKey(), getNotification)
+	r.POST("/events", requireInternalKey(), receiveEvent)
+	r.POST("/internal/revocations", requireInternalKey(), pushRevocation)
+	// Входящие in-app уведомления читает сам пользователь
+	r.GET("/inbox", requireUser(), getInbox)
+	r.POST("/inbox/:id/read", requireUser(), markRead)
 	r.Run(":8083")
 }
@@ -2,42 +2,198 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"booking/shared/jwks"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
+type MockDatabase struct {
+	mock.Mock
+}
+
+func (m *MockDatabase) CreateNotification(n *Notification) error {
+	args := m.Called(n)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CreateEventNotification(n *Notification) (bool, error) {
+	args := m.Called(n)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) FindNotification(id uint) (*Notification, error) {
+	args := m.Called(id)
+	n, _ := args.Get(0).(*Notification)
+	return n, args.Error(1)
+}
+
+func (m *MockDatabase) ClaimNotification(id uint, at, leaseUntil time.Time) (bool, error) {
+	args := m.Called(id, at, leaseUntil)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) DueNotifications(at time.Time, limit int) ([]Notification, error) {
+	args := m.Called(at, limit)
+	due, _ := args.Get(0).([]Notification)
+	return due, args.Error(1)
+}
+
+func (m *MockDatabase) RecordAttempt(n *Notification, attempt *DeliveryAttempt) error {
+	args := m.Called(n, attempt)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ListAttempts(notificationID uint) ([]DeliveryAttempt, error) {
+	args := m.Called(notificationID)
+	attempts, _ := args.Get(0).([]DeliveryAttempt)
+	return attempts, args.Error(1)
+}
+
+func (m *MockDatabase) ListInbox(recipient string, limit int) ([]Notification, error) {
+	args := m.Called(recipient, limit)
+	inbox, _ := args.Get(0).([]Notification)
+	return inbox, args.Error(1)
+}
+
+func (m *MockDatabase) MarkRead(id uint, recipient string, at time.Time) (bool, error) {
+	args := m.Called(id, recipient, at)
+	return args.Bool(0), args.Error(1)
+}
+
+var testKey *rsa.PrivateKey
+
+func TestMain(m *testing.M) {
+	var err error
+	testKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	jwtKeys = jwks.New("")
+	jwtKeys.AddKey("test", &testKey.PublicKey)
+	internalAPIKey = "internal"
+	notifiers = map[string]Notifier{
+		channelInApp:   inAppNotifier{},
+		channelWebhook: newWebhookNotifier(""),
+	}
+	os.Exit(m.Run())
+}
+
+func signedToken(userID uint) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{UserID: userID})
+	token.Header["kid"] = "test"
+	tokenString, _ := token.SignedString(testKey)
+	return tokenString
+}
+
 func TestSendNotification(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	// Устанавливаем роутинг
 	r := gin.Default()
-	r.POST("/notify", sendNotification)
+	r.POST("/notify", requireInternalKey(), sendNotification)
 
-	t.Run("successfully send notification", func(t *testing.T) {
-		notification := `{"message": "Test Notification"}`
-		req, _ := http.NewRequest(http.MethodPost, "/notify", bytes.NewBufferString(notification))
+	post := func(body, auth string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/notify", bytes.NewBufferString(body))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+auth)
 		resp := httptest.NewRecorder()
-
 		r.ServeHTTP(resp, req)
+		return resp
+	}
+	// newDB принимает уведомление, но не доставляет его: фоновая доставка
+	// проверяется отдельно. Уведомление приходит в канал, когда фоновая
+	// горутина уже попыталась его взять и больше не обращается к db.
+	newDB := func() (*MockDatabase, chan *Notification) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		created := make(chan *Notification, 1)
+		var n *Notification
+		mockDB.On("CreateNotification", mock.Anything).Run(func(args mock.Arguments) {
+			n = args.Get(0).(*Notification)
+			n.ID = 9
+		}).Return(nil)
+		mockDB.On("ClaimNotification", uint(9), mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+			created <- n
+		}).Return(false, nil)
+		return mockDB, created
+	}
+
+	t.Run("successfully send notification", func(t *testing.T) {
+		_, created := newDB()
+		notification := `{"recipient": "42", "channel": "in_app", "subject": "Hello", "message": "Test Notification"}`
+
+		resp := post(notification, "internal")
+
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.JSONEq(t, `{"message": "Notification accepted", "id": 9, "status": "pending"}`, resp.Body.String())
+		n := <-created
+		assert.Equal(t, templateMessage, n.Template)
+		assert.Equal(t, "Hello", n.Subject)
+		assert.Equal(t, "Test Notification", n.Body)
+		assert.Equal(t, statusPending, n.Status)
+	})
+
+	t.Run("renders a template from the payload", func(t *testing.T) {
+		_, created := newDB()
+		notification := `{"recipient": "https://hooks.example/x", "channel": "webhook", "template": "password_reset",
+			"payload": {"link": "https://app/reset?token=t", "ttl": "30m0s"}}`
+
+		resp := post(notification, "internal")
 
-		assert.Equal(t, http.StatusOK, resp.Code)
-		assert.JSONEq(t, `{"message": "Notification sent"}`, resp.Body.String())
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		n := <-created
+		assert.Equal(t, "Password reset", n.Subject)
+		assert.Contains(t, n.Body, "https://app/reset?token=t")
+		var payload map[string]string
+		json.Unmarshal([]byte(n.Payload), &payload)
+		assert.Equal(t, "30m0s", payload["ttl"])
 	})
 
 	t.Run("bad request with invalid JSON", func(t *testing.T) {
 		invalidJSON := `{"message": "Test Notification"`
-		req, _ := http.NewRequest(http.MethodPost, "/notify", bytes.NewBufferString(invalidJSON))
-		req.Header.Set("Content-Type", "application/json")
-		resp := httptest.NewRecorder()
 
-		r.ServeHTTP(resp, req)
+		resp := post(invalidJSON, "internal")
 
 		assert.Equal(t, http.StatusBadRequest, resp.Code)
 		assert.Contains(t, resp.Body.String(), "error")
 	})
+
+	t.Run("invalid requests are rejected before saving", func(t *testing.T) {
+		for _, body := range []string{
+			`{"message": "no recipient"}`,
+			`{"recipient": "42", "channel": "pigeon", "message": "x"}`,
+			`{"recipient": "alice", "channel": "in_app", "message": "x"}`,
+			`{"recipient": "file:///etc/passwd", "channel": "webhook", "message": "x"}`,
+			`{"recipient": "42", "channel": "in_app", "template": "nope"}`,
+			`{"recipient": "42", "channel": "in_app", "template": "password_reset", "payload": {"link": "x"}}`,
+			`{"recipient": "alice@example.com", "message": "email is not configured"}`,
+		} {
+			mockDB, _ := newDB()
+
+			resp := post(body, "internal")
+
+			assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+			mockDB.AssertNotCalled(t, "CreateNotification", mock.Anything)
+		}
+	})
+
+	t.Run("requires the internal key", func(t *testing.T) {
+		mockDB, _ := newDB()
+
+		resp := post(`{"recipient": "42", "channel": "in_app", "message": "x"}`, "wrong")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertNotCalled(t, "CreateNotification", mock.Anything)
+	})
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	statusPending = "pending"
+	statusSent    = "sent"
+	statusFailed  = "failed"
+)
+
+const (
+	// maxAttempts — после стольких неудач уведомление помечается failed.
+	maxAttempts = 5
+	// retryBaseDelay удваивается с каждой неудачной попыткой.
+	retryBaseDelay = 30 * time.Second
+	// deliveryLease — на сколько уведомление закрепляется за репликой,
+	// которая его доставляет. Должно быть больше таймаута любого канала.
+	deliveryLease    = 2 * time.Minute
+	dispatchInterval = 5 * time.Second
+	dispatchBatch    = 50
+	inboxLimit       = 100
+)
+
+// Notification — уведомление одному получателю по одному каналу. Тема и
+// текст формируются по шаблону при создании, поэтому повторные попытки
+// отправляют ровно то же самое.
+type Notification struct {
+	gorm.Model
+	Recipient string `json:"recipient" gorm:"index"`
+	Channel   string `json:"channel"`
+	Template  string `json:"template"`
+	// Payload — данные для шаблона в JSON
+	Payload       string     `json:"payload" gorm:"type:jsonb"`
+	Subject       string     `json:"subject"`
+	Body          string     `json:"body"`
+	Status        string     `json:"status" gorm:"index"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	SentAt        *time.Time `json:"sent_at"`
+	ReadAt        *time.Time `json:"read_at,omitempty"`
+	// EventID — ID события другого сервиса, из-за которого создано
+	// уведомление. Повторно доставленное событие второго уведомления не даёт.
+	EventID *string `json:"event_id,omitempty" gorm:"uniqueIndex"`
+}
+
+// DeliveryAttempt — одна попытка доставки и её результат.
+type DeliveryAttempt struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	NotificationID uint      `json:"notification_id" gorm:"index"`
+	Channel        string    `json:"channel"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	AttemptedAt    time.Time `json:"attempted_at"`
+	DurationMS     int64     `json:"duration_ms"`
+}
+
+// notificationRequest — тело POST /notify. Поля subject и message
+// остались от первой версии API: без template они подставляются в шаблон
+// message.
+type notificationRequest struct {
+	Recipient string                 `json:"recipient" binding:"required"`
+	Channel   string                 `json:"channel"`
+	Template  string                 `json:"template"`
+	Payload   map[string]interface{} `json:"payload"`
+	Subject   string                 `json:"subject"`
+	Message   string                 `json:"message"`
+}
+
+// sendNotification — POST /notify. Уведомление сохраняется и доставляется
+// в фоне, поэтому ответ 202 означает только, что оно принято.
+func sendNotification(c *gin.Context) {
+	var req notificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = channelEmail
+	}
+	if req.Template == "" {
+		req.Template = templateMessage
+		req.Payload = map[string]interface{}{"subject": req.Subject, "message": req.Message}
+	}
+
+	n, err := newNotification(req.Recipient, req.Channel, req.Template, req.Payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.CreateNotification(n); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Create notification error: %v", err)
+		return
+	}
+	go dispatch(*n)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Notification accepted", "id": n.ID, "status": n.Status})
+}
+
+// newNotification проверяет получателя, формирует текст по шаблону и
+// возвращает уведомление, готовое к сохранению.
+func newNotification(recipient, channel, template string, payload map[string]interface{}) (*Notification, error) {
+	notifier, ok := notifiers[channel]
+	if !ok {
+		return nil, errors.New("Unknown or disabled channel")
+	}
+	if err := notifier.Validate(recipient); err != nil {
+		return nil, fmt.Errorf("Invalid recipient: %w", err)
+	}
+	subject, body, err := render(template, payload)
+	if err != nil {
+		return nil, err
+	}
+	encoded, _ := json.Marshal(payload)
+
+	return &Notification{
+		Recipient:     recipient,
+		Channel:       channel,
+		Template:      template,
+		Payload:       string(encoded),
+		Subject:       subject,
+		Body:          body,
+		Status:        statusPending,
+		NextAttemptAt: now(),
+	}, nil
+}
+
+// getNotification — GET /notifications/:id, состояние уведомления и все
+// попытки доставки.
+func getNotification(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+	n, err := db.FindNotification(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			log.Printf("Find notification error: %v", err)
+		}
+		return
+	}
+	attempts, err := db.ListAttempts(n.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("List attempts error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notification": n, "attempts": attempts})
+}
+
+var now = time.Now
+
+// dispatch делает одну попытку доставки, если уведомление ещё никто не взял.
+func dispatch(n Notification) {
+	at := now()
+	claimed, err := db.ClaimNotification(n.ID, at, at.Add(deliveryLease))
+	if err != nil {
+		log.Printf("Claim notification %d error: %v", n.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	deliver(&n)
+}
+
+// deliver отправляет уведомление через его канал и записывает попытку.
+// После неудачи следующая попытка откладывается экспоненциально.
+func deliver(n *Notification) {
+	started := now()
+	err := errUnknownChannel
+	if notifier, ok := notifiers[n.Channel]; ok {
+		err = notifier.Send(n)
+	}
+	finished := now()
+
+	attempt := DeliveryAttempt{
+		NotificationID: n.ID,
+		Channel:        n.Channel,
+		Status:         statusSent,
+		AttemptedAt:    started,
+		DurationMS:     finished.Sub(started).Milliseconds(),
+	}
+	n.Attempts++
+	switch {
+	case err == nil:
+		n.Status = statusSent
+		n.SentAt = &finished
+	case n.Attempts >= maxAttempts:
+		n.Status = statusFailed
+	default:
+		n.NextAttemptAt = finished.Add(retryBaseDelay << (n.Attempts - 1))
+	}
+	if err != nil {
+		attempt.Status = statusFailed
+		attempt.Error = err.Error()
+		log.Printf("Deliver notification %d via %s (attempt %d): %v", n.ID, n.Channel, n.Attempts, err)
+	}
+	if err := db.RecordAttempt(n, &attempt); err != nil {
+		log.Printf("Record delivery attempt error: %v", err)
+	}
+}
+
+// runDispatcher подбирает уведомления, которым пора на повторную попытку,
+// и те, что не успели доставить до перезапуска сервиса.
+func runDispatcher(interval time.Duration) {
+	for {
+		due, err := db.DueNotifications(now(), dispatchBatch)
+		if err != nil {
+			log.Printf("Find due notifications error: %v", err)
+		}
+		for _, n := range due {
+			dispatch(n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// getInbox — GET /inbox, in-app уведомления текущего пользователя.
+func getInbox(c *gin.Context) {
+	inbox, err := db.ListInbox(currentRecipient(c), inboxLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("List inbox error: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": inbox})
+}
+
+// markRead — POST /inbox/:id/read.
+func markRead(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+	marked, err := db.MarkRead(uint(id), currentRecipient(c), now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		log.Printf("Mark notification read error: %v", err)
+		return
+	}
+	if !marked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"booking/shared/revocation"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDispatch(t *testing.T) {
+	at := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+
+	server := newFakeSMTP(t)
+	notifiers[channelEmail] = newEmailNotifier(server.addr, "noreply@example.com", "", "")
+	defer delete(notifiers, channelEmail)
+
+	pending := func(recipient string, attempts int) Notification {
+		n := Notification{Recipient: recipient, Channel: channelEmail, Subject: "Hi", Body: "Hello", Status: statusPending, Attempts: attempts}
+		n.ID = 5
+		return n
+	}
+	// recorded возвращает мок, который сохраняет записанную попытку
+	recorded := func(claimed bool) (*MockDatabase, *Notification, *DeliveryAttempt) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		var saved Notification
+		var attempt DeliveryAttempt
+		mockDB.On("ClaimNotification", uint(5), at, at.Add(deliveryLease)).Return(claimed, nil)
+		mockDB.On("RecordAttempt", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			saved = *args.Get(0).(*Notification)
+			attempt = *args.Get(1).(*DeliveryAttempt)
+		}).Return(nil)
+		return mockDB, &saved, &attempt
+	}
+
+	t.Run("delivers an email and records the attempt", func(t *testing.T) {
+		_, saved, attempt := recorded(true)
+
+		dispatch(pending("alice@example.com", 0))
+
+		msg := server.next(t)
+		assert.Equal(t, []string{"alice@example.com"}, msg.to)
+		assert.Equal(t, statusSent, saved.Status)
+		assert.Equal(t, 1, saved.Attempts)
+		require.NotNil(t, saved.SentAt)
+		assert.Equal(t, uint(5), attempt.NotificationID)
+		assert.Equal(t, statusSent, attempt.Status)
+		assert.Empty(t, attempt.Error)
+	})
+
+	t.Run("failed attempt is retried with backoff", func(t *testing.T) {
+		server.reject["ghost@example.com"] = true
+		_, saved, attempt := recorded(true)
+
+		dispatch(pending("ghost@example.com", 2))
+
+		assert.Equal(t, statusPending, saved.Status)
+		assert.Equal(t, 3, saved.Attempts)
+		assert.Equal(t, at.Add(4*retryBaseDelay), saved.NextAttemptAt)
+		assert.Nil(t, saved.SentAt)
+		assert.Equal(t, statusFailed, attempt.Status)
+		assert.Contains(t, attempt.Error, "550")
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		server.reject["ghost@example.com"] = true
+		_, saved, attempt := recorded(true)
+
+		dispatch(pending("ghost@example.com", maxAttempts-1))
+
+		assert.Equal(t, statusFailed, saved.Status)
+		assert.Equal(t, maxAttempts, saved.Attempts)
+		assert.Equal(t, statusFailed, attempt.Status)
+	})
+
+	t.Run("disabled channel is a failed attempt", func(t *testing.T) {
+		_, saved, attempt := recorded(true)
+		n := pending("alice@example.com", 0)
+		n.Channel = "sms"
+
+		dispatch(n)
+
+		assert.Equal(t, 1, saved.Attempts)
+		assert.Equal(t, errUnknownChannel.Error(), attempt.Error)
+	})
+
+	t.Run("skips a notification claimed elsewhere", func(t *testing.T) {
+		mockDB, _, _ := recorded(false)
+
+		dispatch(pending("alice@example.com", 0))
+
+		mockDB.AssertNotCalled(t, "RecordAttempt", mock.Anything, mock.Anything)
+		select {
+		case <-server.messages:
+			t.Fatal("unexpected email")
+		default:
+		}
+	})
+}
+
+func TestGetNotification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/notifications/:id", requireInternalKey(), getNotification)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer internal")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("returns status and attempts", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		n := &Notification{Recipient: "42", Channel: channelInApp, Status: statusSent, Attempts: 2}
+		n.ID = 4
+		mockDB.On("FindNotification", uint(4)).Return(n, nil)
+		mockDB.On("ListAttempts", uint(4)).Return([]DeliveryAttempt{
+			{ID: 1, NotificationID: 4, Status: statusFailed, Error: "boom"},
+			{ID: 2, NotificationID: 4, Status: statusSent},
+		}, nil)
+
+		resp := get("/notifications/4")
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"status":"sent"`)
+		assert.Contains(t, resp.Body.String(), `"error":"boom"`)
+	})
+
+	t.Run("unknown notification", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("FindNotification", uint(8)).Return(nil, gorm.ErrRecordNotFound)
+
+		resp := get("/notifications/8")
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestInbox(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.Default()
+	r.GET("/inbox", requireUser(), getInbox)
+	r.POST("/inbox/:id/read", requireUser(), markRead)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("lists the user's notifications", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("ListInbox", "42", inboxLimit).Return([]Notification{{Recipient: "42", Subject: "Booking confirmed"}}, nil)
+
+		resp := request(http.MethodGet, "/inbox", signedToken(42))
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), "Booking confirmed")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("requires a token", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/inbox", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/inbox", "internal").Code)
+		mockDB.AssertNotCalled(t, "ListInbox", mock.Anything, mock.Anything)
+	})
+
+	t.Run("revoked token is rejected", func(t *testing.T) {
+		saved := revoked
+		defer func() { revoked = saved }()
+		revoked = revocation.New("", "")
+		mockDB := new(MockDatabase)
+		db = mockDB
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{UserID: 42, StandardClaims: jwt.StandardClaims{Id: "j1"}})
+		token.Header["kid"] = "test"
+		tokenString, _ := token.SignedString(testKey)
+		revoked.Add("j1", time.Now().Add(time.Hour))
+
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/inbox", tokenString).Code)
+		mockDB.AssertNotCalled(t, "ListInbox", mock.Anything, mock.Anything)
+	})
+
+	t.Run("marks a notification as read", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("MarkRead", uint(3), "42", mock.Anything).Return(true, nil)
+
+		resp := request(http.MethodPost, "/inbox/3/read", signedToken(42))
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("someone else's notification is not found", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("MarkRead", uint(3), "7", mock.Anything).Return(false, nil)
+
+		resp := request(http.MethodPost, "/inbox/3/read", signedToken(7))
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		db = mockDB
+		mockDB.On("ListInbox", "42", inboxLimit).Return(nil, errors.New("connection refused"))
+
+		resp := request(http.MethodGet, "/inbox", signedToken(42))
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Notifier доставляет уведомление по одному каналу.
+type Notifier interface {
+	// Validate проверяет адрес получателя до сохранения уведомления.
+	Validate(recipient string) error
+	Send(n *Notification) error
+}
+
+const (
+	channelEmail   = "email"
+	channelWebhook = "webhook"
+	channelInApp   = "in_app"
+)
+
+// notifiers — включённые каналы. Email включается заданием SMTP_ADDR,
+// остальные доступны всегда.
+var notifiers = map[string]Notifier{}
+
+var errUnknownChannel = errors.New("channel is not configured")
+
+func loadNotifiers() {
+	notifiers[channelInApp] = inAppNotifier{}
+	notifiers[channelWebhook] = newWebhookNotifier(os.Getenv("WEBHOOK_SECRET"))
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			log.Fatal("SMTP_ADDR requires SMTP_FROM")
+		}
+		notifiers[channelEmail] = newEmailNotifier(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	} else {
+		log.Printf("SMTP_ADDR is not set, email notifications are disabled")
+	}
+}
+
+// inAppNotifier ничего не отправляет: доставленное уведомление просто
+// появляется во входящих пользователя (GET /inbox). Получатель — ID
+// пользователя.
+type inAppNotifier struct{}
+
+func (inAppNotifier) Validate(recipient string) error {
+	if recipient == "" || strings.Trim(recipient, "0123456789") != "" {
+		return errors.New("must be a user ID")
+	}
+	return nil
+}
+
+func (inAppNotifier) Send(*Notification) error {
+	return nil
+}
+
+// Шаблоны уведомлений. Данные берутся из payload; отсутствующий ключ —
+// ошибка, а не пустое место в письме.
+const templateMessage = "message"
+
+type notificationTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newTemplate(name, subject, body string) notificationTemplate {
+	return notificationTemplate{
+		subject: template.Must(template.New(name + ".subject").Option("missingkey=error").Parse(subject)),
+		body:    template.Must(template.New(name + ".body").Option("missingkey=error").Parse(body)),
+	}
+}
+
+var templates = map[string]notificationTemplate{
+	templateMessage: newTemplate(templateMessage, "{{.subject}}", "{{.message}}"),
+	"password_reset": newTemplate("password_reset", "Password reset",
+		"Someone requested a password reset for your account. Open {{.link}} within {{.ttl}} "+
+			"to choose a new password. If it wasn't you, ignore this message."),
+	"booking_created": newTemplate("booking_created", "Booking confirmed",
+		"Your booking of {{.room_name}} from {{.start_time}} to {{.end_time}} is confirmed."),
+	"booking_updated": newTemplate("booking_updated", "Booking changed",
+		"Your booking is now for {{.room_name}} from {{.start_time}} to {{.end_time}}."),
+	"booking_cancelled": newTemplate("booking_cancelled", "Booking cancelled",
+		"Your booking of {{.room_name}} from {{.start_time}} to {{.end_time}} has been cancelled."),
+}
+
+// render формирует тему и текст уведомления.
+func render(name string, payload map[string]interface{}) (string, string, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown template %q", name)
+	}
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, payload); err != nil {
+		return "", "", fmt.Errorf("template %q: %w", name, err)
+	}
+	if err := tmpl.body.Execute(&body, payload); err != nil {
+		return "", "", fmt.Errorf("template %q: %w", name, err)
+	}
+	if strings.TrimSpace(body.String()) == "" {
+		return "", "", errors.New("notification body is empty")
+	}
+	// Перевод строки в теме письма позволил бы дописать свои заголовки
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
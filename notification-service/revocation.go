@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"booking/shared/revocation"
+	"github.com/gin-gonic/gin"
+)
+
+// revoked — отзывы access-токенов из auth-service. Без него выход из
+// системы не закрывал бы доступ к входящим уведомлениям до истечения токена.
+var revoked = revocation.New("", "")
+
+func loadRevocationConfig() {
+	url := os.Getenv("REVOCATION_URL")
+	if url == "" {
+		log.Printf("REVOCATION_URL is not set, revoked tokens are only received by push")
+		return
+	}
+	if internalAPIKey == "" {
+		log.Fatalf("REVOCATION_URL requires INTERNAL_API_KEY")
+	}
+	interval := 30 * time.Second
+	if v := os.Getenv("REVOCATION_SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid REVOCATION_SYNC_INTERVAL: %q", v)
+		}
+		interval = d
+	}
+
+	revoked = revocation.New(url, internalAPIKey)
+	go revoked.Run(interval)
+}
+
+// pushRevocation — POST /internal/revocations, вызывается auth-service сразу
+// после отзыва. Доступ проверяет requireInternalKey.
+func pushRevocation(c *gin.Context) {
+	var entry revocation.Entry
+	if err := c.ShouldBindJSON(&entry); err != nil || entry.JTI == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revocation"})
+		return
+	}
+	revoked.Add(entry.JTI, entry.ExpiresAt)
+	c.JSON(http.StatusOK, gin.H{"message": "Revocation accepted"})
+}
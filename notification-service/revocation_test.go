@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"booking/shared/revocation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	saved := revoked
+	defer func() { revoked = saved }()
+	revoked = revocation.New("", "")
+
+	r := gin.Default()
+	r.POST("/internal/revocations", requireInternalKey(), pushRevocation)
+
+	push := func(auth string) int {
+		body := `{"jti": "j1", "expires_at": "2030-01-01T12:00:00Z"}`
+		req, _ := http.NewRequest(http.MethodPost, "/internal/revocations", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", auth)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, push("Bearer wrong"))
+	assert.False(t, revoked.IsRevoked("j1"))
+
+	assert.Equal(t, http.StatusOK, push("Bearer internal"))
+	assert.True(t, revoked.IsRevoked("j1"))
+}
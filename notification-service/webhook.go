@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// webhookNotifier отправляет уведомление POST-запросом с JSON. Получатель —
+// URL. С WEBHOOK_SECRET тело подписывается HMAC-SHA256, и получатель может
+// проверить, что запрос пришёл от нас.
+type webhookNotifier struct {
+	secret string
+	client *http.Client
+}
+
+func newWebhookNotifier(secret string) *webhookNotifier {
+	return &webhookNotifier{secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *webhookNotifier) Validate(recipient string) error {
+	u, err := url.Parse(recipient)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// webhookSignature — значение заголовка X-Signature для тела и времени.
+// Время входит в подпись, чтобы перехваченный запрос нельзя было повторить
+// позже.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *webhookNotifier) Send(n *Notification) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":       n.ID,
+		"template": n.Template,
+		"subject":  n.Subject,
+		"body":     n.Body,
+		"payload":  json.RawMessage(n.Payload),
+	})
+	req, err := http.NewRequest(http.MethodPost, n.Recipient, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Один и тот же ID во всех попытках позволяет получателю отсеять повторы
+	req.Header.Set("X-Notification-ID", strconv.FormatUint(uint64(n.ID), 10))
+	if w.secret != "" {
+		timestamp := strconv.FormatInt(now().Unix(), 10)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", webhookSignature(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	t.Run("posts a signed payload", func(t *testing.T) {
+		var header http.Header
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		n := &Notification{Recipient: server.URL, Template: templateMessage, Payload: `{"message":"Hi"}`, Subject: "S", Body: "Hi"}
+		n.ID = 3
+
+		require.NoError(t, newWebhookNotifier("hook-secret").Send(n))
+
+		assert.Equal(t, "3", header.Get("X-Notification-ID"))
+		assert.Equal(t, webhookSignature("hook-secret", header.Get("X-Timestamp"), body), header.Get("X-Signature"))
+		var sent map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &sent))
+		assert.Equal(t, "Hi", sent["body"])
+		assert.Equal(t, map[string]interface{}{"message": "Hi"}, sent["payload"])
+	})
+
+	t.Run("unsigned without a secret", func(t *testing.T) {
+		var header http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+		}))
+		defer server.Close()
+
+		require.NoError(t, newWebhookNotifier("").Send(&Notification{Recipient: server.URL, Payload: "{}"}))
+
+		assert.Empty(t, header.Get("X-Signature"))
+	})
+
+	t.Run("non-2xx response is an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		err := newWebhookNotifier("").Send(&Notification{Recipient: server.URL, Payload: "{}"})
+
+		assert.EqualError(t, err, "webhook returned 503")
+	})
+}
@@ -0,0 +1,158 @@
+// Package jwks — открытые ключи auth-service для проверки JWT в других
+// сервисах. Набор загружается с JWKS_URL и перезагружается при неизвестном
+// kid (после ротации) и по истечении срока.
+package jwks
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// ttl — как долго доверяем загруженному набору ключей.
+	ttl = time.Hour
+	// minRefresh — пауза между попытками загрузки, в том числе неудачными.
+	// Не даёт токенам с выдуманным kid заваливать auth-service запросами,
+	// а недоступному auth-service — задерживать каждый запрос.
+	minRefresh = 30 * time.Second
+)
+
+// Cache хранит ключи и загружает их не чаще minRefresh, не больше одной
+// загрузки одновременно. Сетевой запрос идёт без блокировки: пока он
+// выполняется, токены с известным kid проверяются по старому набору.
+type Cache struct {
+	url    string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// inflight закрывается, когда текущая загрузка завершится
+	inflight chan struct{}
+	lastErr  error
+}
+
+// New создаёт кеш для url. С пустым url ключи задаются только через AddKey.
+func New(url string) *Cache {
+	return &Cache{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		keys:   map[string]*rsa.PublicKey{},
+	}
+}
+
+// AddKey добавляет ключ вручную, например в тестах.
+func (c *Cache) AddKey(kid string, key *rsa.PublicKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[kid] = key
+}
+
+// KeyFor возвращает открытый ключ по "kid" токена; подходит как jwt.Keyfunc.
+func (c *Cache) KeyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	stale := time.Since(c.fetchedAt) > ttl
+	if (!ok || stale) && c.url != "" && c.inflight == nil && time.Since(c.attemptedAt) > minRefresh {
+		c.attemptedAt = time.Now()
+		c.inflight = make(chan struct{})
+		go c.refresh(c.inflight)
+	}
+	wait := c.inflight
+	c.mu.Unlock()
+
+	// Известный ключ отдаём сразу, устаревший набор обновится в фоне
+	if ok {
+		return key, nil
+	}
+	if wait != nil {
+		<-wait
+		c.mu.Lock()
+		key, ok = c.keys[kid]
+		err := c.lastErr
+		c.mu.Unlock()
+		if !ok && err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (c *Cache) refresh(done chan struct{}) {
+	keys, err := c.fetch()
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+		c.fetchedAt = time.Now()
+	}
+	c.lastErr = err
+	c.inflight = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// fetch загружает JWKS.
+func (c *Cache) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(body.Keys))
+	for _, k := range body.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
@@ -0,0 +1,183 @@
+package jwks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJWKS имитирует /.well-known/jwks.json из auth-service. Пока задан
+// block, ответы ждут его закрытия; с down отвечает 503.
+type fakeJWKS struct {
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetches int
+	down    bool
+	block   chan struct{}
+}
+
+func (f *fakeJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.fetches++
+	block, down := f.block, f.down
+	keys := []map[string]string{}
+	for kid, pub := range f.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	f.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	if down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (f *fakeJWKS) fetchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func tokenWithKid(kid string) *jwt.Token {
+	return &jwt.Token{Header: map[string]interface{}{"kid": kid}}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	provider := &fakeJWKS{keys: map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}}
+	server := httptest.NewServer(provider)
+	defer server.Close()
+	cache := New(server.URL)
+
+	// Первый запрос загружает JWKS
+	key, err := cache.KeyFor(tokenWithKid("old"))
+	require.NoError(t, err)
+	assert.Equal(t, &oldKey.PublicKey, key)
+	assert.Equal(t, 1, provider.fetchCount())
+
+	// auth-service начал подписывать новым ключом: неизвестный kid
+	// приводит к перезагрузке набора
+	provider.mu.Lock()
+	provider.keys["new"] = &newKey.PublicKey
+	provider.mu.Unlock()
+	cache.attemptedAt = time.Now().Add(-time.Minute)
+
+	key, err = cache.KeyFor(tokenWithKid("new"))
+	require.NoError(t, err)
+	assert.Equal(t, &newKey.PublicKey, key)
+	_, err = cache.KeyFor(tokenWithKid("old"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, provider.fetchCount())
+
+	// Выдуманный kid сразу после загрузки не вызывает новый запрос
+	_, err = cache.KeyFor(tokenWithKid("bogus"))
+	assert.EqualError(t, err, `unknown key id "bogus"`)
+	assert.Equal(t, 2, provider.fetchCount())
+}
+
+func TestKeyForWhileAuthServiceIsDown(t *testing.T) {
+	key := newKey(t)
+	provider := &fakeJWKS{keys: map[string]*rsa.PublicKey{"k": &key.PublicKey}}
+	server := httptest.NewServer(provider)
+	defer server.Close()
+	cache := New(server.URL)
+	_, err := cache.KeyFor(tokenWithKid("k"))
+	require.NoError(t, err)
+
+	// Набор устарел, а auth-service завис и затем отвечает ошибкой
+	release := make(chan struct{})
+	provider.mu.Lock()
+	provider.block, provider.down = release, true
+	provider.mu.Unlock()
+	cache.fetchedAt = time.Now().Add(-2 * ttl)
+	cache.attemptedAt = cache.fetchedAt
+
+	// Пока идёт загрузка, известный ключ отдаётся без ожидания, и вторая
+	// загрузка не начинается
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := cache.KeyFor(tokenWithKid("k"))
+			assert.NoError(t, err)
+			assert.Equal(t, &key.PublicKey, got)
+		}()
+	}
+	wg.Wait()
+	close(release)
+	assert.Eventually(t, func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.inflight == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, provider.fetchCount())
+
+	// Неудачная попытка тоже откладывает следующую
+	_, err = cache.KeyFor(tokenWithKid("k"))
+	assert.NoError(t, err)
+	_, err = cache.KeyFor(tokenWithKid("other"))
+	assert.EqualError(t, err, `unknown key id "other"`)
+	assert.Equal(t, 2, provider.fetchCount())
+}
+
+func TestUnknownKidWaitsForOneFetch(t *testing.T) {
+	key := newKey(t)
+	release := make(chan struct{})
+	provider := &fakeJWKS{keys: map[string]*rsa.PublicKey{"k": &key.PublicKey}, block: release}
+	server := httptest.NewServer(provider)
+	defer server.Close()
+	cache := New(server.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.KeyFor(tokenWithKid("k"))
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, provider.fetchCount())
+}
+
+func TestFetchErrorIsReturned(t *testing.T) {
+	provider := &fakeJWKS{down: true}
+	server := httptest.NewServer(provider)
+	defer server.Close()
+
+	_, err := New(server.URL).KeyFor(tokenWithKid("k"))
+
+	assert.EqualError(t, err, "jwks: unexpected status 503")
+}
@@ -0,0 +1,125 @@
+// Package revocation — локальная копия списка отозванных access-токенов
+// auth-service для сервисов, которые проверяют токены сами.
+package revocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// syncOverlap — на сколько раньше серверного времени auth-service начинаем
+// следующую выгрузку, чтобы не пропустить отзывы из незавершённых транзакций.
+const syncOverlap = 5 * time.Second
+
+// Entry — один отзыв: ID токена (jti) и срок его действия.
+type Entry struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// List пополняется периодической выгрузкой GET /revoked и push-уведомлениями;
+// записи удаляются после истечения соответствующих токенов.
+type List struct {
+	url    string
+	apiKey string
+	client *http.Client
+
+	mu      sync.RWMutex
+	entries map[string]time.Time
+	since   time.Time
+}
+
+// New создаёт список, который выгружается с url (GET /revoked auth-service).
+// С пустым url список пополняется только через Add.
+func New(url, apiKey string) *List {
+	return &List{
+		url:     url,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		entries: map[string]time.Time{},
+	}
+}
+
+func (l *List) Add(jti string, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[jti] = expiresAt
+}
+
+func (l *List) IsRevoked(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.entries[jti]
+	return ok
+}
+
+// Prune удаляет записи о токенах, истёкших к now.
+func (l *List) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for jti, exp := range l.entries {
+		if now.After(exp) {
+			delete(l.entries, jti)
+		}
+	}
+}
+
+// Sync загружает отзывы, появившиеся с прошлой выгрузки.
+func (l *List) Sync() error {
+	l.mu.RLock()
+	since := l.since
+	l.mu.RUnlock()
+
+	endpoint := l.url
+	if !since.IsZero() {
+		endpoint += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if l.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+l.apiKey)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocations: unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Revoked []Entry   `json:"revoked"`
+		Now     time.Time `json:"now"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range body.Revoked {
+		l.entries[e.JTI] = e.ExpiresAt
+	}
+	l.since = body.Now.Add(-syncOverlap)
+	return nil
+}
+
+// Run периодически синхронизирует список. Ошибки только логируются:
+// уже загруженные отзывы продолжают действовать.
+func (l *List) Run(interval time.Duration) {
+	for {
+		if err := l.Sync(); err != nil {
+			log.Printf("Revocation sync error: %v", err)
+		}
+		l.Prune(time.Now())
+		time.Sleep(interval)
+	}
+}
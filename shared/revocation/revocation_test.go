@@ -0,0 +1,51 @@
+package revocation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	serverNow := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	var sinceParams []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		sinceParams = append(sinceParams, r.URL.Query().Get("since"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"revoked": []Entry{{JTI: "j1", ExpiresAt: serverNow.Add(time.Hour)}},
+			"now":     serverNow,
+		})
+	}))
+	defer server.Close()
+
+	list := New(server.URL, "key")
+	require.NoError(t, list.Sync())
+	require.NoError(t, list.Sync())
+
+	assert.True(t, list.IsRevoked("j1"))
+	assert.False(t, list.IsRevoked("j2"))
+	// Первая выгрузка полная, следующая — с момента прошлой минус перекрытие
+	assert.Equal(t, []string{"", serverNow.Add(-syncOverlap).Format(time.RFC3339Nano)}, sinceParams)
+
+	list.Prune(serverNow.Add(2 * time.Hour))
+	assert.False(t, list.IsRevoked("j1"))
+}
+
+func TestSyncError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	list := New(server.URL, "wrong")
+	list.Add("j1", time.Now().Add(time.Hour))
+
+	assert.EqualError(t, list.Sync(), "revocations: unexpected status 401")
+	assert.True(t, list.IsRevoked("j1"))
+}